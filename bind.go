@@ -0,0 +1,418 @@
+package util
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+func envKey(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "_" + name
+}
+
+// ToEnvKey converts a CamelCase field name to an UPPER_SNAKE environment variable key, treating runs of capitals
+// as acronyms, e.g. "ServerPort" to "SERVER_PORT" and "HTTPPort" to "HTTP_PORT".
+func ToEnvKey(field string) string {
+	runes := []rune(field)
+	var b strings.Builder
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			prev := runes[i-1]
+			nextIsLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextIsLower) {
+				b.WriteRune('_')
+			}
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
+
+// FromEnvKey converts an UPPER_SNAKE environment variable key to a CamelCase field name, e.g. "SERVER_PORT" to
+// "ServerPort". Acronyms can't be recovered, so "HTTP_PORT" becomes "HttpPort".
+func FromEnvKey(env string) string {
+	var b strings.Builder
+	for _, word := range strings.Split(env, "_") {
+		runes := []rune(strings.ToLower(word))
+		if len(runes) == 0 {
+			continue
+		}
+		b.WriteRune(unicode.ToUpper(runes[0]))
+		b.WriteString(string(runes[1:]))
+	}
+	return b.String()
+}
+
+// bindEnv sets the fields of the struct pointed to by v that are tagged `env:"NAME"` from the environment
+// variable prefix_NAME, when it is set. Nested structs are walked with the same prefix, or with prefix_NAME
+// when the struct field itself is tagged.
+func bindEnv(lookup envLookup, v any, prefix string) error {
+	return bindEnvFields(lookup, v, prefix, false)
+}
+
+// bindEnvAll binds fields as bindEnv does and also binds untagged fields using their name converted with
+// ToEnvKey. A prefix is required so that untagged fields such as Path or Home aren't set from PATH or HOME.
+func bindEnvAll(lookup envLookup, v any, prefix string) error {
+	if prefix == "" {
+		return fmt.Errorf("a prefix is required to bind untagged fields")
+	}
+	return bindEnvFields(lookup, v, prefix, true)
+}
+
+func bindEnvFields(lookup envLookup, v any, prefix string, untagged bool) error {
+	value := reflect.ValueOf(v)
+	if value.Kind() != reflect.Ptr || value.IsNil() || value.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("expected non-nil pointer to struct, got %T", v)
+	}
+	return bindEnvStruct(lookup, value.Elem(), prefix, untagged)
+}
+
+func bindEnvStruct(lookup envLookup, v reflect.Value, prefix string, untagged bool) error {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		structField := v.Type().Field(i)
+		if !field.CanSet() {
+			continue
+		}
+
+		name := structField.Tag.Get("env")
+		if name == "-" {
+			continue
+		}
+
+		if isNestedStruct(field) {
+			nestedPrefix := prefix
+			if name != "" {
+				nestedPrefix = envKey(prefix, name)
+			}
+			if err := bindEnvNested(lookup, field, nestedPrefix, untagged); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if name == "" {
+			if !untagged {
+				continue
+			}
+			name = ToEnvKey(structField.Name)
+		}
+
+		key := envKey(prefix, name)
+		envValue, ok := lookup(key)
+		if !ok {
+			continue
+		}
+
+		if err := setFieldFromString(field, envValue); err != nil {
+			return fmt.Errorf("unable to set %v from %v: %w", structField.Name, key, err)
+		}
+	}
+	return nil
+}
+
+// isNestedStruct reports whether a field is a struct (or pointer to struct) to walk rather than set directly
+func isNestedStruct(field reflect.Value) bool {
+	t := field.Type()
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || t == timeType {
+		return false
+	}
+	return !reflect.PointerTo(t).Implements(textUnmarshalerType)
+}
+
+func bindEnvNested(lookup envLookup, field reflect.Value, prefix string, untagged bool) error {
+	if field.Kind() != reflect.Ptr {
+		return bindEnvStruct(lookup, field, prefix, untagged)
+	}
+
+	if !field.IsNil() {
+		return bindEnvStruct(lookup, field.Elem(), prefix, untagged)
+	}
+
+	// only allocate a nil nested struct if the environment sets something in it
+	nested := reflect.New(field.Type().Elem())
+	if err := bindEnvStruct(lookup, nested.Elem(), prefix, untagged); err != nil {
+		return err
+	}
+	if !nested.Elem().IsZero() {
+		field.Set(nested)
+	}
+	return nil
+}
+
+// setFieldFromString parses value into the field's type, supporting encoding.TextUnmarshaler, strings, numbers,
+// bools (leniently), durations, times (RFC3339) and comma separated slices.
+func setFieldFromString(field reflect.Value, value string) error {
+	if field.CanAddr() && field.Addr().Type().Implements(textUnmarshalerType) {
+		return field.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(value))
+	}
+
+	switch field.Kind() {
+	case reflect.Ptr:
+		nested := reflect.New(field.Type().Elem())
+		if err := setFieldFromString(nested.Elem(), value); err != nil {
+			return err
+		}
+		field.Set(nested)
+		return nil
+	case reflect.Bool:
+		b, err := parseBoolLenient(strings.TrimSpace(value))
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+		return nil
+	case reflect.Slice:
+		if field.Type().Elem().Kind() == reflect.Uint8 {
+			field.SetBytes([]byte(value))
+			return nil
+		}
+		items := make([]any, 0)
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		return coerceValue(items, field, "")
+	default:
+		return coerceValue(value, field, "")
+	}
+}
+
+// BindEnv sets the fields of the struct pointed to by v that are tagged `env:"NAME"` from the environment
+// variable prefix_NAME (or NAME when prefix is empty), leaving fields whose variable is unset untouched.
+// Untagged fields are left alone. Nested structs are walked with the same prefix, or with prefix_NAME when the
+// struct field itself is tagged.
+func BindEnv(v any, prefix string) error {
+	return bindEnv(os.LookupEnv, v, prefix)
+}
+
+// BindEnvAll binds fields as BindEnv does and also binds untagged fields using their name converted with
+// ToEnvKey, e.g. ServerPort from prefix_SERVER_PORT. The prefix must not be empty, so that untagged fields such
+// as Path or Home can't be set from PATH or HOME.
+func BindEnvAll(v any, prefix string) error {
+	return bindEnvAll(os.LookupEnv, v, prefix)
+}
+
+// ApplyEnvOverrides is an alias of BindEnv, named for overlaying the environment onto a struct that has already
+// been loaded, e.g. by LoadStructFromFile.
+func ApplyEnvOverrides(v any, prefix string) error {
+	return BindEnv(v, prefix)
+}
+
+func applyDefaults(v reflect.Value) error {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		structField := v.Type().Field(i)
+		if !field.CanSet() {
+			continue
+		}
+
+		defaultValue, ok := structField.Tag.Lookup("default")
+		if !ok {
+			if isNestedStruct(field) {
+				if field.Kind() == reflect.Ptr {
+					if field.IsNil() {
+						continue
+					}
+					field = field.Elem()
+				}
+				if err := applyDefaults(field); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		if !field.IsZero() {
+			continue
+		}
+
+		if err := setFieldFromString(field, defaultValue); err != nil {
+			return fmt.Errorf("unable to set %v from default %v: %w", structField.Name, defaultValue, err)
+		}
+	}
+	return nil
+}
+
+// ApplyDefaults sets the zero-valued fields of the struct pointed to by v that are tagged `default:"value"`,
+// parsing value into the field's type as BindEnv does. Fields that already have a value are left alone, so a
+// default can't be used to distinguish an explicit zero value, e.g. false or 0, from an unset field.
+// Nested structs and non-nil pointers to structs are walked.
+func ApplyDefaults(v any) error {
+	value := reflect.ValueOf(v)
+	if value.Kind() != reflect.Ptr || value.IsNil() || value.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("expected non-nil pointer to struct, got %T", v)
+	}
+	return applyDefaults(value.Elem())
+}
+
+// LoadStructFromFileWithDefaults loads a struct like LoadStructFromFile and then fills any fields left unset by
+// the file from their `default:"value"` tags with ApplyDefaults.
+func LoadStructFromFileWithDefaults[T any](filePath string) (*T, error) {
+	data, err := LoadStructFromFile[T](filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ApplyDefaults(data); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// fieldByPath returns the field of struct v named by a dot separated path of field names, e.g. "Database.Host",
+// following pointers. It reports false if a pointer on the path is nil, and errors if a name doesn't exist.
+func fieldByPath(v reflect.Value, path string) (reflect.Value, bool, error) {
+	for _, name := range strings.Split(path, ".") {
+		for v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false, nil
+			}
+			v = v.Elem()
+		}
+		if v.Kind() != reflect.Struct {
+			return reflect.Value{}, false, fmt.Errorf("unknown field %v", path)
+		}
+		structField, ok := v.Type().FieldByName(name)
+		if !ok || !structField.IsExported() {
+			return reflect.Value{}, false, fmt.Errorf("unknown field %v", path)
+		}
+		v = v.FieldByIndex(structField.Index)
+	}
+	return v, true, nil
+}
+
+// RequireFields checks that the fields of the struct pointed to by v named by fieldPaths, dot separated Go field
+// names such as "Database.Host", are not zero valued. It returns an error listing every missing field.
+func RequireFields(v any, fieldPaths ...string) error {
+	value := reflect.ValueOf(v)
+	if value.Kind() != reflect.Ptr || value.IsNil() || value.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("expected non-nil pointer to struct, got %T", v)
+	}
+
+	missing := make([]string, 0)
+	for _, path := range fieldPaths {
+		field, ok, err := fieldByPath(value.Elem(), path)
+		if err != nil {
+			return err
+		}
+		if !ok || field.IsZero() {
+			missing = append(missing, path)
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("missing required fields: %v", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// LoadStructFromFileRequiring loads a struct like LoadStructFromFile and then checks the required fields named by
+// fieldPaths are set with RequireFields.
+func LoadStructFromFileRequiring[T any](filePath string, fieldPaths ...string) (*T, error) {
+	data, err := LoadStructFromFile[T](filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := RequireFields(data, fieldPaths...); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// SchemaVersioned is implemented by configs that report their own schema version to LoadStructFromFileVersioned
+type SchemaVersioned interface {
+	SchemaVersion() int
+}
+
+// schemaVersion returns the schema version of the struct pointed to by v, from its SchemaVersion method or else
+// from an integer field tagged `version:"true"`
+func schemaVersion(v any) (int, error) {
+	if versioned, ok := v.(SchemaVersioned); ok {
+		return versioned.SchemaVersion(), nil
+	}
+
+	value := reflect.ValueOf(v)
+	if value.Kind() != reflect.Ptr || value.IsNil() || value.Elem().Kind() != reflect.Struct {
+		return 0, fmt.Errorf("expected non-nil pointer to struct, got %T", v)
+	}
+
+	elem := value.Elem()
+	for i := 0; i < elem.NumField(); i++ {
+		if elem.Type().Field(i).Tag.Get("version") != "true" {
+			continue
+		}
+		field := elem.Field(i)
+		if !field.CanInt() {
+			return 0, fmt.Errorf("version field %v of %T is not an integer", elem.Type().Field(i).Name, v)
+		}
+		return int(field.Int()), nil
+	}
+	return 0, fmt.Errorf("%T has no SchemaVersion method or field tagged `version:\"true\"`", v)
+}
+
+// LoadStructFromFileVersioned loads a struct like LoadStructFromFile and checks its schema version, taken from
+// its SchemaVersion method or an integer field tagged `version:"true"`, is one of supportedVersions. It returns
+// an error naming the found and supported versions otherwise.
+func LoadStructFromFileVersioned[T any](filePath string, supportedVersions ...int) (*T, error) {
+	data, err := LoadStructFromFile[T](filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := schemaVersion(data)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, supported := range supportedVersions {
+		if version == supported {
+			return data, nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported schema version %d in %v. expected one of %v", version, filePath, supportedVersions)
+}
+
+func loadStructLayered[T any](lookup envLookup, defaults *T, filePath string, envPrefix string) (*T, error) {
+	result := new(T)
+	if defaults != nil {
+		*result = copyValue(reflect.ValueOf(defaults).Elem(), copyPlain).Interface().(T)
+	}
+
+	if filePath != "" {
+		err := LoadStructFromFileInto(filePath, result)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	if err := bindEnv(lookup, result, envPrefix); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// LoadStructLayered builds a struct from layered sources with increasing precedence: defaults, then the
+// yaml/yml or json file at filePath (skipped if it doesn't exist), then environment variables bound with
+// BindEnv using envPrefix. defaults is not modified.
+func LoadStructLayered[T any](defaults *T, filePath string, envPrefix string) (*T, error) {
+	return loadStructLayered(os.LookupEnv, defaults, filePath, envPrefix)
+}
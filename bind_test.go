@@ -0,0 +1,419 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type bindDatabase struct {
+	Host string `yaml:"host" env:"HOST"`
+	Port int    `yaml:"port" env:"PORT"`
+}
+
+type bindConfig struct {
+	Name     string            `yaml:"name" env:"NAME"`
+	Port     int               `yaml:"port" env:"PORT"`
+	Debug    bool              `yaml:"debug" env:"DEBUG"`
+	Timeout  time.Duration     `yaml:"timeout" env:"TIMEOUT"`
+	Tags     []string          `yaml:"tags" env:"TAGS"`
+	Labels   map[string]string `yaml:"labels"`
+	Secret   MaskedString      `yaml:"secret" env:"SECRET"`
+	Database bindDatabase      `yaml:"database" env:"DB"`
+	Cache    *bindDatabase     `yaml:"cache" env:"CACHE"`
+	Ignored  string            `yaml:"ignored" env:"-"`
+	Untagged string            `yaml:"untagged"`
+}
+
+func TestBindEnv(t *testing.T) {
+	lookup := mockLookupEnvMap(map[string]string{
+		"APP_NAME":       "env-name",
+		"APP_PORT":       "9090",
+		"APP_DEBUG":      "yes",
+		"APP_TIMEOUT":    "5s",
+		"APP_TAGS":       "a, b,c",
+		"APP_SECRET":     "hunter2",
+		"APP_DB_HOST":    "db.local",
+		"APP_CACHE_PORT": "6379",
+		"APP_IGNORED":    "ignored",
+		"APP_UNTAGGED":   "untagged",
+	})
+
+	cfg := &bindConfig{Database: bindDatabase{Port: 5432}}
+	if err := bindEnv(lookup, cfg, "APP"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if cfg.Name != "env-name" || cfg.Port != 9090 || !cfg.Debug || cfg.Timeout != 5*time.Second {
+		t.Errorf("unexpected scalar values %+v", cfg)
+	}
+	if len(cfg.Tags) != 3 || cfg.Tags[0] != "a" || cfg.Tags[2] != "c" {
+		t.Errorf("expected tags [a b c] got %v", cfg.Tags)
+	}
+	if cfg.Secret.UnmaskedString() != "hunter2" {
+		t.Errorf("expected secret 'hunter2' got '%s'", cfg.Secret.UnmaskedString())
+	}
+	if cfg.Database.Host != "db.local" || cfg.Database.Port != 5432 {
+		t.Errorf("expected database {db.local 5432} got %+v", cfg.Database)
+	}
+	if cfg.Cache == nil || cfg.Cache.Port != 6379 {
+		t.Errorf("expected cache port 6379 got %+v", cfg.Cache)
+	}
+	if cfg.Ignored != "" || cfg.Untagged != "" {
+		t.Errorf("expected ignored and untagged fields to be left alone got %+v", cfg)
+	}
+}
+
+func TestBindEnvInvalid(t *testing.T) {
+	cfg := &bindConfig{}
+	if err := bindEnv(mockLookupEnv("PORT", "eighty"), cfg, ""); err == nil {
+		t.Errorf("expected error for invalid int")
+	}
+	if err := bindEnv(mockLookupEnv("PORT", "80"), bindConfig{}, ""); err == nil {
+		t.Errorf("expected error for non-pointer")
+	}
+}
+
+func TestLoadStructLayered(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	content := "name: file-name\nport: 8081\nlabels:\n  from: file\ndatabase:\n  host: file-db\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	defaults := &bindConfig{
+		Name:     "default-name",
+		Port:     8080,
+		Untagged: "default-untagged",
+		Labels:   map[string]string{"from": "defaults", "default": "true"},
+		Database: bindDatabase{Host: "default-db", Port: 5432},
+	}
+
+	lookup := mockLookupEnvMap(map[string]string{
+		"APP_PORT": "9090",
+	})
+
+	cfg, err := loadStructLayered(lookup, defaults, path, "APP")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if cfg.Port != 9090 {
+		t.Errorf("expected env to override file, got port %d", cfg.Port)
+	}
+	if cfg.Name != "file-name" {
+		t.Errorf("expected file to override defaults, got name '%s'", cfg.Name)
+	}
+	if cfg.Untagged != "default-untagged" {
+		t.Errorf("expected default to remain, got '%s'", cfg.Untagged)
+	}
+	if cfg.Database.Host != "file-db" || cfg.Database.Port != 5432 {
+		t.Errorf("expected nested merge {file-db 5432} got %+v", cfg.Database)
+	}
+	if cfg.Labels["from"] != "file" {
+		t.Errorf("expected file label got %v", cfg.Labels)
+	}
+
+	if defaults.Name != "default-name" || defaults.Port != 8080 || defaults.Labels["from"] != "defaults" {
+		t.Errorf("expected defaults to be unchanged got %+v", defaults)
+	}
+}
+
+func TestLoadStructLayeredMissingFile(t *testing.T) {
+	defaults := &bindConfig{Name: "default-name", Port: 8080}
+
+	cfg, err := loadStructLayered(mockLookupEnv("APP_NAME", "env-name"), defaults, filepath.Join(t.TempDir(), "missing.yaml"), "APP")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if cfg.Name != "env-name" || cfg.Port != 8080 {
+		t.Errorf("expected {env-name 8080} got %+v", cfg)
+	}
+}
+
+func TestBindEnvUntaggedFields(t *testing.T) {
+	type server struct {
+		ServerPort int
+		HTTPHost   string
+		Name       string `env:"SERVICE_NAME"`
+	}
+
+	lookup := mockLookupEnvMap(map[string]string{
+		"APP_SERVER_PORT":  "8443",
+		"APP_HTTP_HOST":    "example.com",
+		"APP_SERVICE_NAME": "svc",
+		"APP_NAME":         "ignored",
+	})
+
+	cfg := &server{}
+	if err := bindEnv(lookup, cfg, "APP"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if cfg.ServerPort != 0 || cfg.HTTPHost != "" || cfg.Name != "svc" {
+		t.Errorf("expected only the tagged field bound by default got %+v", cfg)
+	}
+
+	cfg = &server{}
+	if err := bindEnvAll(lookup, cfg, "APP"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if cfg.ServerPort != 8443 || cfg.HTTPHost != "example.com" || cfg.Name != "svc" {
+		t.Errorf("expected {8443 example.com svc} got %+v", cfg)
+	}
+
+	type shell struct {
+		Path string
+		Home string
+	}
+	if err := bindEnvAll(mockLookupEnv("PATH", "/usr/bin"), &shell{}, ""); err == nil {
+		t.Errorf("expected error binding untagged fields without a prefix")
+	}
+}
+
+func TestToEnvKey(t *testing.T) {
+	tests := []struct {
+		field    string
+		expected string
+	}{
+		{field: "Port", expected: "PORT"},
+		{field: "ServerPort", expected: "SERVER_PORT"},
+		{field: "MaxIdleConnections", expected: "MAX_IDLE_CONNECTIONS"},
+		{field: "HTTPPort", expected: "HTTP_PORT"},
+		{field: "UserID", expected: "USER_ID"},
+		{field: "ID", expected: "ID"},
+		{field: "APIKeyURL", expected: "API_KEY_URL"},
+		{field: "OAuth2Token", expected: "O_AUTH2_TOKEN"},
+		{field: "port", expected: "PORT"},
+		{field: "", expected: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.field, func(t *testing.T) {
+			if got := ToEnvKey(tt.field); got != tt.expected {
+				t.Errorf("expected '%s' got '%s'", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestFromEnvKey(t *testing.T) {
+	tests := []struct {
+		env      string
+		expected string
+	}{
+		{env: "PORT", expected: "Port"},
+		{env: "SERVER_PORT", expected: "ServerPort"},
+		{env: "MAX_IDLE_CONNECTIONS", expected: "MaxIdleConnections"},
+		{env: "HTTP_PORT", expected: "HttpPort"},
+		{env: "server__port_", expected: "ServerPort"},
+		{env: "", expected: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.env, func(t *testing.T) {
+			if got := FromEnvKey(tt.env); got != tt.expected {
+				t.Errorf("expected '%s' got '%s'", tt.expected, got)
+			}
+		})
+	}
+
+	for _, field := range []string{"Port", "ServerPort", "MaxIdleConnections"} {
+		if got := FromEnvKey(ToEnvKey(field)); got != field {
+			t.Errorf("expected '%s' to round trip got '%s'", field, got)
+		}
+	}
+}
+
+type defaultsServer struct {
+	Host    string        `yaml:"host" default:"localhost"`
+	Port    int           `yaml:"port" default:"8080"`
+	Timeout time.Duration `yaml:"timeout" default:"30s"`
+}
+
+type defaultsConfig struct {
+	Name    string          `yaml:"name" default:"app"`
+	Debug   bool            `yaml:"debug" default:"true"`
+	Ratio   float64         `yaml:"ratio" default:"0.5"`
+	Tags    []string        `yaml:"tags" default:"a,b"`
+	Server  defaultsServer  `yaml:"server"`
+	Backup  *defaultsServer `yaml:"backup"`
+	NoValue string          `yaml:"noValue"`
+}
+
+func TestLoadStructFromFileWithDefaults(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	content := "name: from-file\nserver:\n  port: 9090\nbackup:\n  host: backup.local\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	cfg, err := LoadStructFromFileWithDefaults[defaultsConfig](path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if cfg.Name != "from-file" {
+		t.Errorf("expected file value 'from-file' got '%s'", cfg.Name)
+	}
+	if !cfg.Debug || cfg.Ratio != 0.5 || len(cfg.Tags) != 2 || cfg.Tags[1] != "b" {
+		t.Errorf("expected defaults for debug, ratio and tags got %+v", cfg)
+	}
+	if cfg.Server.Host != "localhost" || cfg.Server.Port != 9090 || cfg.Server.Timeout != 30*time.Second {
+		t.Errorf("expected {localhost 9090 30s} got %+v", cfg.Server)
+	}
+	if cfg.Backup == nil || cfg.Backup.Host != "backup.local" || cfg.Backup.Port != 8080 {
+		t.Errorf("expected {backup.local 8080 30s} got %+v", cfg.Backup)
+	}
+	if cfg.NoValue != "" {
+		t.Errorf("expected untagged field to be left alone got '%s'", cfg.NoValue)
+	}
+}
+
+func TestApplyDefaults(t *testing.T) {
+	cfg := &defaultsConfig{}
+	if err := ApplyDefaults(cfg); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if cfg.Name != "app" || cfg.Server.Port != 8080 || cfg.Backup != nil {
+		t.Errorf("unexpected defaults %+v", cfg)
+	}
+
+	type invalid struct {
+		Port int `default:"eighty"`
+	}
+	if err := ApplyDefaults(&invalid{}); err == nil {
+		t.Errorf("expected error for invalid default")
+	}
+	if err := ApplyDefaults(defaultsConfig{}); err == nil {
+		t.Errorf("expected error for non-pointer")
+	}
+}
+
+func TestLoadStructFromFileRequiring(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		name          string
+		content       string
+		errorContains []string
+	}{
+		{name: "complete", content: "name: app\nport: 8080\ndatabase:\n  host: db.local\ncache:\n  host: cache.local\n"},
+		{name: "missing fields", content: "name: app\ndatabase:\n  port: 5432\n", errorContains: []string{"Port", "Database.Host", "Cache.Host"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name+".yaml")
+			if err := os.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			cfg, err := LoadStructFromFileRequiring[bindConfig](path, "Name", "Port", "Database.Host", "Cache.Host")
+			if len(tt.errorContains) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				if cfg.Database.Host != "db.local" {
+					t.Errorf("expected 'db.local' got '%s'", cfg.Database.Host)
+				}
+				return
+			}
+
+			if err == nil {
+				t.Fatalf("expected error")
+			}
+			for _, missing := range tt.errorContains {
+				if !strings.Contains(err.Error(), missing) {
+					t.Errorf("expected error to name '%s' got '%s'", missing, err)
+				}
+			}
+			if strings.Contains(err.Error(), "Name") {
+				t.Errorf("expected error to name only missing fields got '%s'", err)
+			}
+		})
+	}
+}
+
+func TestRequireFieldsInvalid(t *testing.T) {
+	cfg := &bindConfig{Name: "app"}
+	if err := RequireFields(cfg, "Nmae"); err == nil || !strings.Contains(err.Error(), "unknown field") {
+		t.Errorf("expected unknown field error got %v", err)
+	}
+	if err := RequireFields(cfg, "Name.Length"); err == nil {
+		t.Errorf("expected error for path through non-struct")
+	}
+	if err := RequireFields(*cfg, "Name"); err == nil {
+		t.Errorf("expected error for non-pointer")
+	}
+	if err := RequireFields(cfg, "Name"); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+type taggedVersionConfig struct {
+	Version int    `yaml:"version" json:"version" version:"true"`
+	Name    string `yaml:"name" json:"name"`
+}
+
+type methodVersionConfig struct {
+	Schema int    `yaml:"schema" json:"schema"`
+	Name   string `yaml:"name" json:"name"`
+}
+
+func (c *methodVersionConfig) SchemaVersion() int {
+	return c.Schema
+}
+
+func TestLoadStructFromFileVersioned(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		name          string
+		content       string
+		errorExpected bool
+	}{
+		{name: "matching", content: "version: 2\nschema: 2\nname: app\n"},
+		{name: "mismatching", content: "version: 3\nschema: 3\nname: app\n", errorExpected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name+".yaml")
+			if err := os.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			tagged, taggedErr := LoadStructFromFileVersioned[taggedVersionConfig](path, 1, 2)
+			method, methodErr := LoadStructFromFileVersioned[methodVersionConfig](path, 1, 2)
+
+			for _, err := range []error{taggedErr, methodErr} {
+				if !tt.errorExpected {
+					if err != nil {
+						t.Fatalf("unexpected error: %s", err)
+					}
+					continue
+				}
+				if err == nil {
+					t.Fatalf("expected error")
+				}
+				if !strings.Contains(err.Error(), "3") || !strings.Contains(err.Error(), "[1 2]") {
+					t.Errorf("expected error to name found and supported versions got '%s'", err)
+				}
+			}
+
+			if !tt.errorExpected && (tagged.Name != "app" || method.Name != "app") {
+				t.Errorf("expected 'app' got '%s' and '%s'", tagged.Name, method.Name)
+			}
+		})
+	}
+
+	path := filepath.Join(dir, "unversioned.yaml")
+	if err := os.WriteFile(path, []byte("name: app\ncount: 1\n"), 0600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := LoadStructFromFileVersioned[testConfig](path, 1); err == nil {
+		t.Errorf("expected error for struct without a version")
+	}
+}
@@ -0,0 +1,93 @@
+package util
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var byteUnits = map[string]float64{
+	"":    1,
+	"b":   1,
+	"k":   1 << 10,
+	"kb":  1e3,
+	"kib": 1 << 10,
+	"m":   1 << 20,
+	"mb":  1e6,
+	"mib": 1 << 20,
+	"g":   1 << 30,
+	"gb":  1e9,
+	"gib": 1 << 30,
+	"t":   1 << 40,
+	"tb":  1e12,
+	"tib": 1 << 40,
+	"p":   1 << 50,
+	"pb":  1e15,
+	"pib": 1 << 50,
+	"e":   1 << 60,
+	"eb":  1e18,
+	"eib": 1 << 60,
+}
+
+var binaryByteUnits = []string{"B", "KiB", "MiB", "GiB", "TiB", "PiB", "EiB"}
+
+// ParseBytes parses a human-readable size such as "512", "10KB", "1.5 GiB" or "2M" into a number of bytes.
+// IEC units (KiB, MiB, ...) and single letter units (K, M, ...) are binary, SI units (KB, MB, ...) are decimal.
+// Units are case-insensitive.
+func ParseBytes(s string) (int64, error) {
+	trimmed := strings.TrimSpace(s)
+
+	split := strings.IndexFunc(trimmed, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if split == -1 {
+		split = len(trimmed)
+	}
+
+	number := trimmed[:split]
+	unit := strings.ToLower(strings.TrimSpace(trimmed[split:]))
+
+	if number == "" {
+		return 0, fmt.Errorf("unable to parse %v as bytes: missing number", s)
+	}
+
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unable to parse %v as bytes: %w", s, err)
+	}
+
+	multiplier, ok := byteUnits[unit]
+	if !ok {
+		return 0, fmt.Errorf("unable to parse %v as bytes: unknown unit %q", s, unit)
+	}
+
+	bytes := math.Round(value * multiplier)
+	if bytes >= math.MaxInt64 {
+		return 0, fmt.Errorf("unable to parse %v as bytes: value out of range", s)
+	}
+
+	return int64(bytes), nil
+}
+
+// FormatBytes formats a number of bytes using binary units, e.g. 1536 is formatted as "1.5 KiB".
+// Values are rounded to at most two decimal places.
+func FormatBytes(n int64) string {
+	sign := ""
+	value := float64(n)
+	if n < 0 {
+		sign = "-"
+		value = -value
+	}
+
+	unit := 0
+	for value >= 1024 && unit < len(binaryByteUnits)-1 {
+		value /= 1024
+		unit++
+	}
+
+	formatted := strconv.FormatFloat(value, 'f', 2, 64)
+	formatted = strings.TrimRight(strings.TrimRight(formatted, "0"), ".")
+
+	return fmt.Sprintf("%s%s %s", sign, formatted, binaryByteUnits[unit])
+}
@@ -0,0 +1,90 @@
+package util
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseBytes(t *testing.T) {
+	tests := []struct {
+		input         string
+		expected      int64
+		errorExpected bool
+	}{
+		{input: "0", expected: 0},
+		{input: "512", expected: 512},
+		{input: "512B", expected: 512},
+		{input: "1024", expected: 1024},
+		{input: "1KiB", expected: 1024},
+		{input: "1 kib", expected: 1024},
+		{input: "1K", expected: 1024},
+		{input: "1KB", expected: 1000},
+		{input: "1.5 GiB", expected: 1536 * 1024 * 1024},
+		{input: "1.5KiB", expected: 1536},
+		{input: "0.5 MiB", expected: 512 * 1024},
+		{input: "2MB", expected: 2000000},
+		{input: " 3 TiB ", expected: 3 << 40},
+		{input: "", errorExpected: true},
+		{input: "GiB", errorExpected: true},
+		{input: "1.2.3 KiB", errorExpected: true},
+		{input: "10 XB", errorExpected: true},
+		{input: "-1 KiB", errorExpected: true},
+		{input: "16 EiB", errorExpected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			value, err := ParseBytes(tt.input)
+			if tt.errorExpected {
+				if err == nil {
+					t.Fatalf("expected error, got %v", value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if value != tt.expected {
+				t.Errorf("expected %d got %d", tt.expected, value)
+			}
+		})
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		input    int64
+		expected string
+	}{
+		{input: 0, expected: "0 B"},
+		{input: 1, expected: "1 B"},
+		{input: 1023, expected: "1023 B"},
+		{input: 1024, expected: "1 KiB"},
+		{input: 1536, expected: "1.5 KiB"},
+		{input: 1536 * 1024 * 1024, expected: "1.5 GiB"},
+		{input: 1 << 40, expected: "1 TiB"},
+		{input: -2048, expected: "-2 KiB"},
+		{input: math.MaxInt64, expected: "8 EiB"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expected, func(t *testing.T) {
+			if value := FormatBytes(tt.input); value != tt.expected {
+				t.Errorf("expected '%s' got '%s'", tt.expected, value)
+			}
+		})
+	}
+}
+
+func TestBytesRoundTrip(t *testing.T) {
+	for _, n := range []int64{0, 1, 1023, 1024, 1536, 1 << 20, 3 * (1 << 30), 5 << 40} {
+		formatted := FormatBytes(n)
+		parsed, err := ParseBytes(formatted)
+		if err != nil {
+			t.Fatalf("unexpected error parsing %s: %s", formatted, err)
+		}
+		if parsed != n {
+			t.Errorf("expected %d got %d (via '%s')", n, parsed, formatted)
+		}
+	}
+}
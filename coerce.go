@@ -0,0 +1,290 @@
+package util
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	timeType     = reflect.TypeOf(time.Time{})
+	urlType      = reflect.TypeOf(url.URL{})
+)
+
+// fieldKey returns the key used for a struct field by the given struct tag (json or yaml)
+// and whether the field should be inlined into its parent
+func fieldKey(field reflect.StructField, tagName string) (string, bool, bool) {
+	tag := field.Tag.Get(tagName)
+	if tag == "-" {
+		return "", false, false
+	}
+
+	name, opts, _ := strings.Cut(tag, ",")
+	inline := field.Anonymous && name == "" || strings.Contains(","+opts+",", ",inline,")
+
+	return name, inline, true
+}
+
+// lookupFieldValue finds the value for a struct field in a decoded map, matching the tag name first
+// and then the field name case-insensitively
+func lookupFieldValue(m map[string]any, name string, field reflect.StructField) (any, bool) {
+	if name != "" {
+		value, ok := m[name]
+		return value, ok
+	}
+	for k, value := range m {
+		if strings.EqualFold(k, field.Name) {
+			return value, true
+		}
+	}
+	return nil, false
+}
+
+func toStringKeyMap(src any) (map[string]any, bool) {
+	switch m := src.(type) {
+	case map[string]any:
+		return m, true
+	case map[any]any:
+		converted := make(map[string]any, len(m))
+		for k, v := range m {
+			converted[fmt.Sprint(k)] = v
+		}
+		return converted, true
+	default:
+		return nil, false
+	}
+}
+
+// coerceValue sets dst from a generically decoded src value (maps, slices, strings, numbers and bools),
+// converting between strings and numbers/bools/durations where the types don't match.
+func coerceValue(src any, dst reflect.Value, tagName string) error {
+	if src == nil {
+		return nil
+	}
+
+	if srcValue := reflect.ValueOf(src); srcValue.Type().AssignableTo(dst.Type()) {
+		dst.Set(srcValue)
+		return nil
+	}
+
+	if dst.Type() == timeType {
+		if s, ok := src.(string); ok {
+			t, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(s))
+			if err != nil {
+				return fmt.Errorf("unable to coerce %q to time: %w", s, err)
+			}
+			dst.Set(reflect.ValueOf(t))
+			return nil
+		}
+	}
+
+	if dst.Type() == durationType {
+		if s, ok := src.(string); ok {
+			d, err := time.ParseDuration(strings.TrimSpace(s))
+			if err != nil {
+				return fmt.Errorf("unable to coerce %q to duration: %w", s, err)
+			}
+			dst.SetInt(int64(d))
+			return nil
+		}
+	}
+
+	if dst.Type() == urlType {
+		if s, ok := src.(string); ok {
+			u, err := url.Parse(strings.TrimSpace(s))
+			if err != nil {
+				return fmt.Errorf("unable to coerce %q to URL: %w", s, err)
+			}
+			dst.Set(reflect.ValueOf(*u))
+			return nil
+		}
+	}
+
+	if ok, err := coerceUnmarshaler(src, dst, tagName); ok {
+		return err
+	}
+
+	switch dst.Kind() {
+	case reflect.Ptr:
+		value := reflect.New(dst.Type().Elem())
+		if err := coerceValue(src, value.Elem(), tagName); err != nil {
+			return err
+		}
+		dst.Set(value)
+		return nil
+	case reflect.Struct:
+		m, ok := toStringKeyMap(src)
+		if !ok {
+			return fmt.Errorf("unable to coerce %T to %v", src, dst.Type())
+		}
+		return coerceStruct(m, dst, tagName)
+	case reflect.Map:
+		m, ok := toStringKeyMap(src)
+		if !ok {
+			return fmt.Errorf("unable to coerce %T to %v", src, dst.Type())
+		}
+		result := reflect.MakeMapWithSize(dst.Type(), len(m))
+		for k, v := range m {
+			key := reflect.New(dst.Type().Key()).Elem()
+			if err := coerceValue(k, key, tagName); err != nil {
+				return err
+			}
+			value := reflect.New(dst.Type().Elem()).Elem()
+			if err := coerceValue(v, value, tagName); err != nil {
+				return fmt.Errorf("%v: %w", k, err)
+			}
+			result.SetMapIndex(key, value)
+		}
+		dst.Set(result)
+		return nil
+	case reflect.Slice:
+		items, ok := src.([]any)
+		if !ok {
+			return fmt.Errorf("unable to coerce %T to %v", src, dst.Type())
+		}
+		result := reflect.MakeSlice(dst.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := coerceValue(item, result.Index(i), tagName); err != nil {
+				return fmt.Errorf("[%d]: %w", i, err)
+			}
+		}
+		dst.Set(result)
+		return nil
+	default:
+		return coerceScalar(src, dst)
+	}
+}
+
+// coerceUnmarshaler sets dst from src using dst's own unmarshaling, reporting whether dst supports it. Scalar
+// values are given as text to types implementing encoding.TextUnmarshaler, so a number can set a text type.
+// Otherwise types implementing json.Unmarshaler (for json) or yaml.Unmarshaler (for yaml) are given src
+// re-encoded in that format.
+func coerceUnmarshaler(src any, dst reflect.Value, tagName string) (bool, error) {
+	if !dst.CanAddr() {
+		return false, nil
+	}
+	target := dst.Addr().Interface()
+
+	_, isMap := toStringKeyMap(src)
+	_, isSlice := src.([]any)
+	if u, ok := target.(encoding.TextUnmarshaler); ok && !isMap && !isSlice {
+		s, isString := src.(string)
+		if !isString {
+			s = fmt.Sprint(src)
+		}
+		return true, u.UnmarshalText([]byte(s))
+	}
+
+	if u, ok := target.(json.Unmarshaler); ok && tagName == "json" {
+		data, err := json.Marshal(src)
+		if err != nil {
+			return true, err
+		}
+		return true, u.UnmarshalJSON(data)
+	}
+
+	if u, ok := target.(yaml.Unmarshaler); ok && tagName != "json" {
+		var node yaml.Node
+		if err := node.Encode(src); err != nil {
+			return true, err
+		}
+		return true, u.UnmarshalYAML(&node)
+	}
+
+	return false, nil
+}
+
+func coerceStruct(m map[string]any, dst reflect.Value, tagName string) error {
+	for i := 0; i < dst.NumField(); i++ {
+		field := dst.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, inline, ok := fieldKey(field, tagName)
+		if !ok {
+			continue
+		}
+
+		if inline && field.Type.Kind() == reflect.Struct {
+			if err := coerceStruct(m, dst.Field(i), tagName); err != nil {
+				return err
+			}
+			continue
+		}
+
+		value, ok := lookupFieldValue(m, name, field)
+		if !ok {
+			continue
+		}
+
+		if err := coerceValue(value, dst.Field(i), tagName); err != nil {
+			return fmt.Errorf("%v: %w", field.Name, err)
+		}
+	}
+	return nil
+}
+
+func coerceScalar(src any, dst reflect.Value) error {
+	s, isString := src.(string)
+	if !isString {
+		s = fmt.Sprint(src)
+	}
+	s = strings.TrimSpace(s)
+
+	switch dst.Kind() {
+	case reflect.String:
+		if isString {
+			dst.SetString(src.(string))
+		} else {
+			dst.SetString(s)
+		}
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("unable to coerce %q to bool: %w", s, err)
+		}
+		dst.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(s, 10, dst.Type().Bits())
+		if err != nil {
+			f, ferr := strconv.ParseFloat(s, 64)
+			if ferr != nil || f != float64(int64(f)) || dst.OverflowInt(int64(f)) {
+				return fmt.Errorf("unable to coerce %q to %v: %w", s, dst.Type(), err)
+			}
+			i = int64(f)
+		}
+		dst.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(s, 10, dst.Type().Bits())
+		if err != nil {
+			f, ferr := strconv.ParseFloat(s, 64)
+			if ferr != nil || f < 0 || f != float64(uint64(f)) || dst.OverflowUint(uint64(f)) {
+				return fmt.Errorf("unable to coerce %q to %v: %w", s, dst.Type(), err)
+			}
+			u = uint64(f)
+		}
+		dst.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, dst.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("unable to coerce %q to %v: %w", s, dst.Type(), err)
+		}
+		dst.SetFloat(f)
+	default:
+		value := reflect.ValueOf(src)
+		if !value.Type().ConvertibleTo(dst.Type()) {
+			return fmt.Errorf("unable to coerce %T to %v", src, dst.Type())
+		}
+		dst.Set(value.Convert(dst.Type()))
+	}
+	return nil
+}
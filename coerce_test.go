@@ -0,0 +1,216 @@
+package util
+
+import (
+	"encoding/json"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dioad/generics"
+	"gopkg.in/yaml.v3"
+)
+
+type lenientConfig struct {
+	Port     int               `json:"port" yaml:"port"`
+	Ratio    float64           `json:"ratio" yaml:"ratio"`
+	Enabled  bool              `json:"enabled" yaml:"enabled"`
+	Name     string            `json:"name" yaml:"name"`
+	Timeout  time.Duration     `json:"timeout" yaml:"timeout"`
+	Ports    []uint16          `json:"ports" yaml:"ports"`
+	Limits   map[string]int    `json:"limits" yaml:"limits"`
+	Nested   *lenientConfig    `json:"nested" yaml:"nested"`
+	Labels   map[string]string `json:"labels" yaml:"labels"`
+	Untagged int
+}
+
+func TestLoadStructFromFileLenient(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+	}{
+		{
+			name: "yaml",
+			file: "config.yaml",
+			content: `port: "8080"
+ratio: "0.5"
+enabled: "true"
+name: 1234
+timeout: 5s
+ports: ["80", 443]
+limits:
+  cpu: "2"
+nested:
+  port: "9090"
+labels:
+  version: 2
+untagged: "7"
+`,
+		},
+		{
+			name: "json",
+			file: "config.json",
+			content: `{
+  "port": "8080",
+  "ratio": "0.5",
+  "enabled": "true",
+  "name": 1234,
+  "timeout": "5s",
+  "ports": ["80", 443],
+  "limits": {"cpu": "2"},
+  "nested": {"port": "9090"},
+  "labels": {"version": 2},
+  "Untagged": "7"
+}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			if err := os.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if _, err := LoadStructFromFile[lenientConfig](path); err == nil {
+				t.Fatalf("expected strict load to fail")
+			}
+
+			cfg, err := LoadStructFromFileLenient[lenientConfig](path)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if cfg.Port != 8080 {
+				t.Errorf("expected port 8080 got %d", cfg.Port)
+			}
+			if cfg.Ratio != 0.5 {
+				t.Errorf("expected ratio 0.5 got %f", cfg.Ratio)
+			}
+			if !cfg.Enabled {
+				t.Errorf("expected enabled")
+			}
+			if cfg.Name != "1234" {
+				t.Errorf("expected name '1234' got '%s'", cfg.Name)
+			}
+			if cfg.Timeout != 5*time.Second {
+				t.Errorf("expected timeout 5s got %s", cfg.Timeout)
+			}
+			if len(cfg.Ports) != 2 || cfg.Ports[0] != 80 || cfg.Ports[1] != 443 {
+				t.Errorf("expected ports [80 443] got %v", cfg.Ports)
+			}
+			if cfg.Limits["cpu"] != 2 {
+				t.Errorf("expected cpu limit 2 got %v", cfg.Limits)
+			}
+			if cfg.Nested == nil || cfg.Nested.Port != 9090 {
+				t.Errorf("expected nested port 9090 got %v", cfg.Nested)
+			}
+			if cfg.Labels["version"] != "2" {
+				t.Errorf("expected version label '2' got %v", cfg.Labels)
+			}
+			if cfg.Untagged != 7 {
+				t.Errorf("expected untagged 7 got %d", cfg.Untagged)
+			}
+		})
+	}
+}
+
+func TestLoadStructFromFileLenientInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("port: eighty\n"), 0600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if _, err := LoadStructFromFileLenient[lenientConfig](path); err == nil {
+		t.Errorf("expected error for value that can't be coerced")
+	}
+}
+
+// upperList unmarshals a list of strings in upper case, from yaml or json
+type upperList []string
+
+func (l *upperList) UnmarshalYAML(value *yaml.Node) error {
+	var items []string
+	if err := value.Decode(&items); err != nil {
+		return err
+	}
+	*l = upperList(generics.SafeMap(strings.ToUpper, items))
+	return nil
+}
+
+func (l *upperList) UnmarshalJSON(data []byte) error {
+	var items []string
+	if err := json.Unmarshal(data, &items); err != nil {
+		return err
+	}
+	*l = upperList(generics.SafeMap(strings.ToUpper, items))
+	return nil
+}
+
+type lenientUnmarshalerConfig struct {
+	Port     int            `json:"port" yaml:"port"`
+	Password MaskedString   `json:"password" yaml:"password"`
+	Token    *MaskedString  `json:"token" yaml:"token"`
+	PIN      MaskedString   `json:"pin" yaml:"pin"`
+	Keys     []MaskedString `json:"keys" yaml:"keys"`
+	Endpoint url.URL        `json:"endpoint" yaml:"endpoint"`
+	Regions  upperList      `json:"regions" yaml:"regions"`
+}
+
+func TestLoadStructFromFileLenientUnmarshalers(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+	}{
+		{
+			name:    "yaml",
+			file:    "config.yaml",
+			content: "port: \"8080\"\npassword: hunter2\ntoken: t0ken\npin: 1234\nkeys: [key-1]\nendpoint: https://example.com/api\nregions: [eu, us]\n",
+		},
+		{
+			name:    "json",
+			file:    "config.json",
+			content: `{"port": "8080", "password": "hunter2", "token": "t0ken", "pin": 1234, "keys": ["key-1"], "endpoint": "https://example.com/api", "regions": ["eu", "us"]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			if err := os.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			cfg, err := LoadStructFromFileLenient[lenientUnmarshalerConfig](path)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if cfg.Port != 8080 {
+				t.Errorf("expected port 8080 got %d", cfg.Port)
+			}
+			if cfg.Password.UnmaskedString() != "hunter2" {
+				t.Errorf("expected 'hunter2' got '%s'", cfg.Password.UnmaskedString())
+			}
+			if cfg.Token == nil || cfg.Token.UnmaskedString() != "t0ken" {
+				t.Errorf("expected 't0ken' got %v", cfg.Token)
+			}
+			if cfg.PIN.UnmaskedString() != "1234" {
+				t.Errorf("expected '1234' got '%s'", cfg.PIN.UnmaskedString())
+			}
+			if len(cfg.Keys) != 1 || cfg.Keys[0].UnmaskedString() != "key-1" {
+				t.Errorf("expected 'key-1' got %v", cfg.Keys)
+			}
+			if cfg.Endpoint.String() != "https://example.com/api" {
+				t.Errorf("expected 'https://example.com/api' got '%s'", cfg.Endpoint.String())
+			}
+			if len(cfg.Regions) != 2 || cfg.Regions[0] != "EU" || cfg.Regions[1] != "US" {
+				t.Errorf("expected [EU US] got %v", cfg.Regions)
+			}
+		})
+	}
+}
@@ -1,12 +1,22 @@
 package util
 
 import (
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"io/fs"
 	"net/url"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
+	"unicode"
 )
 
+// ErrEnvNotSet is returned when a required environment variable is not set
+var ErrEnvNotSet = errors.New("environment variable not set")
+
 type envLookup func(string) (string, bool)
 
 // lookupEnvWithDefault is a helper function that returns a value from an environment variable with a default value
@@ -17,6 +27,27 @@ func lookupEnvWithDefault(lookup envLookup, key, defaultValue string) string {
 	return defaultValue
 }
 
+// trimEnvValue trims surrounding whitespace and then a single layer of matching single or double quotes
+func trimEnvValue(value string) string {
+	value = strings.TrimSpace(value)
+	if len(value) >= 2 {
+		first, last := value[0], value[len(value)-1]
+		if first == last && (first == '"' || first == '\'') {
+			return value[1 : len(value)-1]
+		}
+	}
+	return value
+}
+
+// lookupEnvWithDefaultTrimmed is a helper function that returns a trimmed value from an environment variable
+// with a default value
+func lookupEnvWithDefaultTrimmed(lookup envLookup, key, defaultValue string) string {
+	if value, ok := lookup(key); ok {
+		return trimEnvValue(value)
+	}
+	return defaultValue
+}
+
 // lookupEnvBool is a helper function that returns a boolean value from an environment variable
 func lookupEnvBool(lookup envLookup, key string) bool {
 	if value, ok := lookup(key); ok {
@@ -25,6 +56,91 @@ func lookupEnvBool(lookup envLookup, key string) bool {
 	return false
 }
 
+// lookupEnvBoolStrict is a helper function that returns a boolean value from an environment variable, accepting
+// only the case-insensitive "true" and "false" recognised by lookupEnvBool
+func lookupEnvBoolStrict(lookup envLookup, key string) (bool, error) {
+	value, err := lookupEnv(lookup, key)
+	if err != nil {
+		return false, err
+	}
+	switch strings.ToLower(value) {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	default:
+		return false, fmt.Errorf("unable to parse %v as bool, expected true or false", value)
+	}
+}
+
+// lookupEnv is a helper function that returns a value from an environment variable, erroring if it is not set
+func lookupEnv(lookup envLookup, key string) (string, error) {
+	value, ok := lookup(key)
+	if !ok {
+		return "", fmt.Errorf("%w: %v", ErrEnvNotSet, key)
+	}
+	return value, nil
+}
+
+// lookupEnvInt is a helper function that returns an integer value from an environment variable
+func lookupEnvInt(lookup envLookup, key string) (int, error) {
+	value, err := lookupEnv(lookup, key)
+	if err != nil {
+		return 0, err
+	}
+
+	i, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("unable to parse %v as int: %w", value, err)
+	}
+	return i, nil
+}
+
+// lookupEnvFirst is a helper function that returns the value of the first key set in the environment
+func lookupEnvFirst(lookup envLookup, keys ...string) (string, bool) {
+	for _, key := range keys {
+		if value, ok := lookup(key); ok {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// lookupEnvFirstWithDefault is a helper function that returns the value of the first key set in the environment
+// or a default value if none are set
+func lookupEnvFirstWithDefault(lookup envLookup, defaultValue string, keys ...string) string {
+	if value, ok := lookupEnvFirst(lookup, keys...); ok {
+		return value
+	}
+	return defaultValue
+}
+
+// parseBoolLenient parses the strconv.ParseBool set of values plus yes/no/on/off/y/n, case-insensitively
+func parseBoolLenient(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "1", "t", "true", "y", "yes", "on":
+		return true, nil
+	case "0", "f", "false", "n", "no", "off":
+		return false, nil
+	}
+	return false, fmt.Errorf("unable to parse %v as bool", value)
+}
+
+// lookupEnvBoolLenient is a helper function that returns a leniently parsed boolean value from an environment variable
+func lookupEnvBoolLenient(lookup envLookup, key string) (bool, error) {
+	value, ok := lookup(key)
+	if !ok {
+		return false, fmt.Errorf("%w: %v", ErrEnvNotSet, key)
+	}
+	return parseBoolLenient(value)
+}
+
+// featureEnabled is a helper function that reports whether an environment variable is set to a truthy value
+func featureEnabled(lookup envLookup, key string) bool {
+	enabled, err := lookupEnvBoolLenient(lookup, key)
+	return err == nil && enabled
+}
+
 // lookupEnvURL is a helper function that returns a URL from an environment variable
 func lookupEnvURL(lookup envLookup, key string) (*url.URL, error) {
 	if value, ok := lookup(key); ok {
@@ -37,17 +153,417 @@ func lookupEnvURL(lookup envLookup, key string) (*url.URL, error) {
 	return nil, nil
 }
 
+// lookupEnvTime is a helper function that returns a time parsed with layout, or time.RFC3339 if layout is empty,
+// from an environment variable
+func lookupEnvTime(lookup envLookup, key, layout string) (time.Time, error) {
+	value, err := lookupEnv(lookup, key)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if layout == "" {
+		layout = time.RFC3339
+	}
+
+	t, err := time.Parse(layout, strings.TrimSpace(value))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("unable to parse %v as time: %w", value, err)
+	}
+	return t, nil
+}
+
+// lookupEnvTimeWithDefault is a helper function that returns a time from an environment variable with a default
+// value, erroring only if the variable is set and can't be parsed
+func lookupEnvTimeWithDefault(lookup envLookup, key, layout string, defaultValue time.Time) (time.Time, error) {
+	t, err := lookupEnvTime(lookup, key, layout)
+	if errors.Is(err, ErrEnvNotSet) {
+		return defaultValue, nil
+	}
+	return t, err
+}
+
+// decodeBase64 decodes standard or URL-safe base64, with or without padding
+func decodeBase64(value string) ([]byte, error) {
+	encoding := base64.RawStdEncoding
+	if strings.ContainsAny(value, "-_") {
+		encoding = base64.RawURLEncoding
+	}
+	return encoding.DecodeString(strings.TrimRight(value, "="))
+}
+
+// lookupEnvBase64 is a helper function that returns base64 decoded bytes from an environment variable
+func lookupEnvBase64(lookup envLookup, key string) ([]byte, error) {
+	value, ok := lookup(key)
+	if !ok {
+		return nil, fmt.Errorf("%w: %v", ErrEnvNotSet, key)
+	}
+
+	data, err := decodeBase64(value)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode %v as base64: %w", key, err)
+	}
+	return data, nil
+}
+
+// lookupEnvStringMap is a helper function that returns a map of key/value pairs from an environment variable
+func lookupEnvStringMap(lookup envLookup, key, pairSep, kvSep string) (map[string]string, error) {
+	value, ok := lookup(key)
+	if !ok {
+		return nil, fmt.Errorf("%w: %v", ErrEnvNotSet, key)
+	}
+
+	result := make(map[string]string)
+	for _, pair := range strings.Split(value, pairSep) {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		k, v, found := strings.Cut(pair, kvSep)
+		if !found {
+			return nil, fmt.Errorf("unable to parse %v: pair %q missing separator %q", key, pair, kvSep)
+		}
+		result[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return result, nil
+}
+
+// lookupEnvURLSlice is a helper function that returns a slice of URLs from a comma and/or whitespace separated
+// environment variable
+func lookupEnvURLSlice(lookup envLookup, key string) ([]*url.URL, error) {
+	value, err := lookupEnv(lookup, key)
+	if err != nil {
+		return nil, err
+	}
+
+	elements := strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+
+	urls := make([]*url.URL, 0, len(elements))
+	for i, element := range elements {
+		u, err := url.Parse(element)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse element %d (%v) of %v as URL: %w", i, element, key, err)
+		}
+		urls = append(urls, u)
+	}
+	return urls, nil
+}
+
+// readTrimmedFile reads the (expanded) file at path, trimming surrounding whitespace such as a trailing newline
+func readTrimmedFile(path string) (string, error) {
+	expandedPath, err := ExpandPath(path)
+	if err != nil {
+		return "", err
+	}
+
+	content, err := os.ReadFile(expandedPath) // #nosec
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(content)), nil
+}
+
+// resolveString is a helper function that returns the value of an environment variable, else the trimmed
+// content of a file, else a default value
+func resolveString(lookup envLookup, envKey, filePath, defaultValue string) (string, error) {
+	if envKey != "" {
+		if value, ok := lookup(envKey); ok {
+			return value, nil
+		}
+	}
+
+	if filePath != "" {
+		value, err := readTrimmedFile(filePath)
+		if err == nil {
+			return value, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+	}
+
+	return defaultValue, nil
+}
+
+// lookupEnvOrFile is a helper function that returns the value of key, or the trimmed content of the file named
+// by key_FILE, erroring if neither is set
+func lookupEnvOrFile(lookup envLookup, key string) (string, error) {
+	if value, ok := lookup(key); ok {
+		return value, nil
+	}
+
+	fileKey := key + "_FILE"
+	if filePath, ok := lookup(fileKey); ok {
+		value, err := readTrimmedFile(filePath)
+		if err != nil {
+			return "", fmt.Errorf("unable to read %v from %v: %w", key, fileKey, err)
+		}
+		return value, nil
+	}
+
+	return "", fmt.Errorf("%w: %v or %v", ErrEnvNotSet, key, fileKey)
+}
+
+// lookupEnvMapped is a helper function that returns the value in mapping for an environment variable's value
+func lookupEnvMapped[T any](lookup envLookup, key string, mapping map[string]T) (T, error) {
+	var zero T
+
+	value, err := lookupEnv(lookup, key)
+	if err != nil {
+		return zero, err
+	}
+
+	mapped, ok := mapping[strings.TrimSpace(value)]
+	if !ok {
+		validKeys := make([]string, 0, len(mapping))
+		for k := range mapping {
+			validKeys = append(validKeys, k)
+		}
+		sort.Strings(validKeys)
+		return zero, fmt.Errorf("invalid value %v for %v. expected one of %v", value, key, strings.Join(validKeys, ", "))
+	}
+	return mapped, nil
+}
+
 // LookupEnvWithDefault is a wrapper around os.LookupEnv that returns a default value if the environment variable is not set
 func LookupEnvWithDefault(key, defaultValue string) string {
 	return lookupEnvWithDefault(os.LookupEnv, key, defaultValue)
 }
 
+// LookupEnvWithDefaultTrimmed is a wrapper around os.LookupEnv that returns a default value if the environment
+// variable is not set. Surrounding whitespace and a single layer of matching quotes are trimmed from the value.
+func LookupEnvWithDefaultTrimmed(key, defaultValue string) string {
+	return lookupEnvWithDefaultTrimmed(os.LookupEnv, key, defaultValue)
+}
+
+// ResolveString returns the value of the environment variable envKey if it is set, otherwise the trimmed content
+// of filePath if it exists (e.g. a mounted secret), otherwise defaultValue. An empty envKey or filePath skips
+// that source. Errors reading a file that does exist are returned.
+func ResolveString(envKey, filePath, defaultValue string) (string, error) {
+	return resolveString(os.LookupEnv, envKey, filePath, defaultValue)
+}
+
+// LookupEnvOrFile is a wrapper around os.LookupEnv that follows the container secrets convention, returning the
+// value of key if set, otherwise the trimmed content of the file named by key_FILE, e.g. DB_PASSWORD_FILE set to
+// /run/secrets/db_password. It returns ErrEnvNotSet if neither is set.
+func LookupEnvOrFile(key string) (string, error) {
+	return lookupEnvOrFile(os.LookupEnv, key)
+}
+
+// LookupEnvFirst is a wrapper around os.LookupEnv that returns the value of the first key set in the environment
+func LookupEnvFirst(keys ...string) (string, bool) {
+	return lookupEnvFirst(os.LookupEnv, keys...)
+}
+
+// LookupEnvFirstWithDefault is a wrapper around os.LookupEnv that returns the value of the first key set in the
+// environment or a default value if none are set
+func LookupEnvFirstWithDefault(defaultValue string, keys ...string) string {
+	return lookupEnvFirstWithDefault(os.LookupEnv, defaultValue, keys...)
+}
+
+// Coalesce returns the value of the first provider that reports it was found, calling providers in order and
+// stopping at the first found, e.g. an env lookup, then a file, then a literal default. It returns the zero value
+// and false if none are found.
+func Coalesce[T any](providers ...func() (T, bool)) (T, bool) {
+	for _, provider := range providers {
+		if value, ok := provider(); ok {
+			return value, true
+		}
+	}
+	var zero T
+	return zero, false
+}
+
+// LookupEnvInt is a wrapper around os.LookupEnv that returns an integer value
+func LookupEnvInt(key string) (int, error) {
+	return lookupEnvInt(os.LookupEnv, key)
+}
+
+// LookupEnvTime is a wrapper around os.LookupEnv that returns a time parsed with layout, or time.RFC3339 if
+// layout is empty. It returns ErrEnvNotSet if the environment variable is not set.
+func LookupEnvTime(key, layout string) (time.Time, error) {
+	return lookupEnvTime(os.LookupEnv, key, layout)
+}
+
+// LookupEnvTimeWithDefault is a wrapper around os.LookupEnv that returns a time parsed with layout, or
+// time.RFC3339 if layout is empty, returning defaultValue if the environment variable is not set
+func LookupEnvTimeWithDefault(key, layout string, defaultValue time.Time) (time.Time, error) {
+	return lookupEnvTimeWithDefault(os.LookupEnv, key, layout, defaultValue)
+}
+
+// LookupEnvMapped is a wrapper around os.LookupEnv that returns the value in mapping for the environment
+// variable's value, e.g. mapping "json" to a FormatJSON constant. It returns ErrEnvNotSet if the variable is not
+// set and an error listing the valid values if it isn't in mapping.
+func LookupEnvMapped[T any](key string, mapping map[string]T) (T, error) {
+	return lookupEnvMapped(os.LookupEnv, key, mapping)
+}
+
 // LookupEnvBool is a wrapper around os.LookupEnv that returns a boolean value
 func LookupEnvBool(key string) bool {
 	return lookupEnvBool(os.LookupEnv, key)
 }
 
+// LookupEnvBoolLenient is a wrapper around os.LookupEnv that returns a boolean value,
+// accepting yes/no/on/off/y/n in addition to the values accepted by strconv.ParseBool
+func LookupEnvBoolLenient(key string) (bool, error) {
+	return lookupEnvBoolLenient(os.LookupEnv, key)
+}
+
+// FeatureEnabled reports whether the feature flag environment variable is set to a truthy value, as parsed by
+// LookupEnvBoolLenient. Unset and unparseable values are treated as disabled rather than returning an error.
+func FeatureEnabled(key string) bool {
+	return featureEnabled(os.LookupEnv, key)
+}
+
 // LookupEnvURL is a wrapper around os.LookupEnv that returns a URL
 func LookupEnvURL(key string) (*url.URL, error) {
 	return lookupEnvURL(os.LookupEnv, key)
 }
+
+// LookupEnvURLSlice is a wrapper around os.LookupEnv that returns a slice of URLs,
+// e.g. "https://a https://b" or "https://a,https://b"
+func LookupEnvURLSlice(key string) ([]*url.URL, error) {
+	return lookupEnvURLSlice(os.LookupEnv, key)
+}
+
+// LookupEnvBase64 is a wrapper around os.LookupEnv that returns base64 decoded bytes.
+// Both standard and URL-safe encodings are accepted, with or without padding.
+func LookupEnvBase64(key string) ([]byte, error) {
+	return lookupEnvBase64(os.LookupEnv, key)
+}
+
+// LookupEnvStringMap is a wrapper around os.LookupEnv that returns a map of key/value pairs,
+// e.g. "env=prod,team=core" with pairSep "," and kvSep "="
+func LookupEnvStringMap(key, pairSep, kvSep string) (map[string]string, error) {
+	return lookupEnvStringMap(os.LookupEnv, key, pairSep, kvSep)
+}
+
+// mustLookup panics with a descriptive message if err is not nil, otherwise it returns value
+func mustLookup[T any](key string, value T, err error) T {
+	if err != nil {
+		panic(fmt.Sprintf("unable to lookup environment variable %v: %v", key, err))
+	}
+	return value
+}
+
+// MustLookupEnv returns the value of an environment variable, panicking if it is not set
+func MustLookupEnv(key string) string {
+	value, err := lookupEnv(os.LookupEnv, key)
+	return mustLookup(key, value, err)
+}
+
+// MustLookupEnvInt returns an integer value from an environment variable, panicking if it is not set or invalid
+func MustLookupEnvInt(key string) int {
+	value, err := LookupEnvInt(key)
+	return mustLookup(key, value, err)
+}
+
+// MustLookupEnvBool returns a boolean value from an environment variable, parsed like LookupEnvBool,
+// panicking if it is not set or is neither true nor false
+func MustLookupEnvBool(key string) bool {
+	value, err := lookupEnvBoolStrict(os.LookupEnv, key)
+	return mustLookup(key, value, err)
+}
+
+// MustLookupEnvURL returns a URL from an environment variable, panicking if it is not set or invalid
+func MustLookupEnvURL(key string) *url.URL {
+	value, err := LookupEnvURL(key)
+	if err == nil && value == nil {
+		err = ErrEnvNotSet
+	}
+	return mustLookup(key, value, err)
+}
+
+// MustLookupEnvBase64 returns base64 decoded bytes from an environment variable, panicking if it is not set or invalid
+func MustLookupEnvBase64(key string) []byte {
+	value, err := LookupEnvBase64(key)
+	return mustLookup(key, value, err)
+}
+
+// Env looks up environment variables scoped by a prefix, e.g. Int("PORT") on NewEnv("SVCA") reads SVCA_PORT.
+type Env struct {
+	prefix string
+	lookup envLookup
+}
+
+// NewEnv creates an Env that prepends prefix and an underscore to every key. An empty prefix leaves keys unchanged.
+func NewEnv(prefix string) *Env {
+	return &Env{
+		prefix: prefix,
+		lookup: os.LookupEnv,
+	}
+}
+
+// Key returns the prefixed environment variable name for key
+func (e *Env) Key(key string) string {
+	return envKey(e.prefix, key)
+}
+
+// Lookup returns the value of the prefixed key and whether it is set
+func (e *Env) Lookup(key string) (string, bool) {
+	return e.lookup(e.Key(key))
+}
+
+// WithDefault returns the value of the prefixed key or defaultValue if it is not set
+func (e *Env) WithDefault(key, defaultValue string) string {
+	return lookupEnvWithDefault(e.lookup, e.Key(key), defaultValue)
+}
+
+// Int returns the value of the prefixed key as an integer
+func (e *Env) Int(key string) (int, error) {
+	return lookupEnvInt(e.lookup, e.Key(key))
+}
+
+// Bool returns true if the value of the prefixed key is "true" (case-insensitive)
+func (e *Env) Bool(key string) bool {
+	return lookupEnvBool(e.lookup, e.Key(key))
+}
+
+// BoolLenient returns the value of the prefixed key as a leniently parsed boolean
+func (e *Env) BoolLenient(key string) (bool, error) {
+	return lookupEnvBoolLenient(e.lookup, e.Key(key))
+}
+
+// URL returns the value of the prefixed key as a URL, or nil if it is not set
+func (e *Env) URL(key string) (*url.URL, error) {
+	return lookupEnvURL(e.lookup, e.Key(key))
+}
+
+// URLSlice returns the value of the prefixed key as a slice of URLs
+func (e *Env) URLSlice(key string) ([]*url.URL, error) {
+	return lookupEnvURLSlice(e.lookup, e.Key(key))
+}
+
+// Base64 returns the base64 decoded value of the prefixed key
+func (e *Env) Base64(key string) ([]byte, error) {
+	return lookupEnvBase64(e.lookup, e.Key(key))
+}
+
+// StringMap returns the value of the prefixed key as a map of key/value pairs
+func (e *Env) StringMap(key, pairSep, kvSep string) (map[string]string, error) {
+	return lookupEnvStringMap(e.lookup, e.Key(key), pairSep, kvSep)
+}
+
+// splitEnvEntry splits a KEY=value entry from os.Environ, allowing for Windows entries such as "=C:=C:\"
+// whose key starts with "="
+func splitEnvEntry(entry string) (string, string) {
+	i := strings.Index(entry[min(1, len(entry)):], "=")
+	if i == -1 {
+		return entry, ""
+	}
+	i += min(1, len(entry))
+	return entry[:i], entry[i+1:]
+}
+
+// EnvironMap returns the process environment as a map, splitting each entry from os.Environ on the first "=" after
+// the start of the key
+func EnvironMap() map[string]string {
+	env := make(map[string]string)
+	for _, entry := range os.Environ() {
+		key, value := splitEnvEntry(entry)
+		env[key] = value
+	}
+	return env
+}
@@ -1,8 +1,16 @@
 package util
 
 import (
+	"bytes"
+	"encoding/base64"
+	"errors"
 	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func mockLookupEnv(lookupKey, result string) envLookup {
@@ -42,6 +50,116 @@ func TestLookupEnvWithDefault(t *testing.T) {
 	}
 }
 
+func TestLookupEnvWithDefaultTrimmed(t *testing.T) {
+	tests := []struct {
+		name       string
+		key        string
+		lookupFunc envLookup
+		expected   string
+	}{
+		{name: "no trimming", key: "TEST_KEY", lookupFunc: mockLookupEnv("TEST_KEY", "value"), expected: "value"},
+		{name: "spaces", key: "TEST_KEY", lookupFunc: mockLookupEnv("TEST_KEY", "  value \t\n"), expected: "value"},
+		{name: "double quotes", key: "TEST_KEY", lookupFunc: mockLookupEnv("TEST_KEY", ` "value" `), expected: "value"},
+		{name: "single quotes", key: "TEST_KEY", lookupFunc: mockLookupEnv("TEST_KEY", "'value'"), expected: "value"},
+		{name: "one layer of quotes", key: "TEST_KEY", lookupFunc: mockLookupEnv("TEST_KEY", `"'value'"`), expected: "'value'"},
+		{name: "quoted spaces kept", key: "TEST_KEY", lookupFunc: mockLookupEnv("TEST_KEY", `" value "`), expected: " value "},
+		{name: "mismatched quotes", key: "TEST_KEY", lookupFunc: mockLookupEnv("TEST_KEY", `"value'`), expected: `"value'`},
+		{name: "lone quote", key: "TEST_KEY", lookupFunc: mockLookupEnv("TEST_KEY", `"`), expected: `"`},
+		{name: "default", key: "TEST_KEY_NO_VALUE", lookupFunc: mockLookupEnv("TEST_KEY", "value"), expected: " default "},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if value := lookupEnvWithDefaultTrimmed(tt.lookupFunc, tt.key, " default "); value != tt.expected {
+				t.Errorf("expected '%s' got '%s'", tt.expected, value)
+			}
+		})
+	}
+
+	if value := lookupEnvWithDefault(mockLookupEnv("TEST_KEY", " 'value' "), "TEST_KEY", ""); value != " 'value' " {
+		t.Errorf("expected untrimmed value got '%s'", value)
+	}
+}
+
+func TestResolveString(t *testing.T) {
+	dir := t.TempDir()
+	secretPath := filepath.Join(dir, "secret")
+	if err := os.WriteFile(secretPath, []byte("  from-file\n"), 0600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	tests := []struct {
+		name       string
+		lookupFunc envLookup
+		filePath   string
+		expected   string
+	}{
+		{name: "env set", lookupFunc: mockLookupEnv("TEST_KEY", "from-env"), filePath: secretPath, expected: "from-env"},
+		{name: "env set empty", lookupFunc: mockLookupEnv("TEST_KEY", ""), filePath: secretPath, expected: ""},
+		{name: "file present", lookupFunc: mockLookupEnv("OTHER_KEY", "other"), filePath: secretPath, expected: "from-file"},
+		{name: "default", lookupFunc: mockLookupEnv("OTHER_KEY", "other"), filePath: filepath.Join(dir, "missing"), expected: "default"},
+		{name: "no file path", lookupFunc: mockLookupEnv("OTHER_KEY", "other"), expected: "default"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := resolveString(tt.lookupFunc, "TEST_KEY", tt.filePath, "default")
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if value != tt.expected {
+				t.Errorf("expected '%s' got '%s'", tt.expected, value)
+			}
+		})
+	}
+
+	if _, err := resolveString(mockLookupEnv("OTHER_KEY", "other"), "TEST_KEY", dir, "default"); err == nil {
+		t.Errorf("expected error reading a directory")
+	}
+}
+
+func TestLookupEnvOrFile(t *testing.T) {
+	dir := t.TempDir()
+	secretPath := filepath.Join(dir, "db_password")
+	if err := os.WriteFile(secretPath, []byte("from-file\n"), 0600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	tests := []struct {
+		name          string
+		env           map[string]string
+		expected      string
+		expectedErr   error
+		errorExpected bool
+	}{
+		{name: "direct value", env: map[string]string{"DB_PASSWORD": "from-env", "DB_PASSWORD_FILE": secretPath}, expected: "from-env"},
+		{name: "file", env: map[string]string{"DB_PASSWORD_FILE": secretPath}, expected: "from-file"},
+		{name: "missing file", env: map[string]string{"DB_PASSWORD_FILE": filepath.Join(dir, "missing")}, errorExpected: true},
+		{name: "neither", env: map[string]string{}, expectedErr: ErrEnvNotSet, errorExpected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := lookupEnvOrFile(mockLookupEnvMap(tt.env), "DB_PASSWORD")
+			if tt.errorExpected {
+				if err == nil {
+					t.Errorf("expected error")
+				}
+				if tt.expectedErr != nil && !errors.Is(err, tt.expectedErr) {
+					t.Errorf("expected '%v' got '%v'", tt.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if value != tt.expected {
+				t.Errorf("expected '%s' got '%s'", tt.expected, value)
+			}
+		})
+	}
+}
+
 func TestLookupEnvBool(t *testing.T) {
 	tests := []struct {
 		key        string
@@ -143,3 +261,624 @@ func TestLookupEnvURL(t *testing.T) {
 		}
 	}
 }
+
+func TestLookupEnvBoolLenient(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected bool
+	}{
+		{value: "1", expected: true},
+		{value: "t", expected: true},
+		{value: "T", expected: true},
+		{value: "true", expected: true},
+		{value: "TRUE", expected: true},
+		{value: "True", expected: true},
+		{value: "y", expected: true},
+		{value: "Y", expected: true},
+		{value: "yes", expected: true},
+		{value: "YES", expected: true},
+		{value: "on", expected: true},
+		{value: "ON", expected: true},
+		{value: "0", expected: false},
+		{value: "f", expected: false},
+		{value: "F", expected: false},
+		{value: "false", expected: false},
+		{value: "FALSE", expected: false},
+		{value: "n", expected: false},
+		{value: "N", expected: false},
+		{value: "no", expected: false},
+		{value: "No", expected: false},
+		{value: "off", expected: false},
+		{value: "OFF", expected: false},
+	}
+
+	for _, test := range tests {
+		value, err := lookupEnvBoolLenient(mockLookupEnv("TEST_KEY", test.value), "TEST_KEY")
+		if err != nil {
+			t.Fatalf("unexpected error for %v: %v", test.value, err)
+		}
+		if value != test.expected {
+			t.Fatalf("expected %v for %v, got %v", test.expected, test.value, value)
+		}
+	}
+}
+
+func TestLookupEnvBoolLenientInvalid(t *testing.T) {
+	if _, err := lookupEnvBoolLenient(mockLookupEnv("TEST_KEY", "maybe"), "TEST_KEY"); err == nil {
+		t.Fatalf("expected error for invalid value")
+	}
+
+	_, err := lookupEnvBoolLenient(mockLookupEnv("TEST_KEY", "true"), "TEST_KEY_NO_VALUE")
+	if !errors.Is(err, ErrEnvNotSet) {
+		t.Fatalf("expected ErrEnvNotSet, got %v", err)
+	}
+}
+
+func TestFeatureEnabled(t *testing.T) {
+	tests := []struct {
+		name     string
+		lookup   envLookup
+		expected bool
+	}{
+		{name: "set true", lookup: mockLookupEnv("FEATURE_X", "true"), expected: true},
+		{name: "set yes", lookup: mockLookupEnv("FEATURE_X", "Yes"), expected: true},
+		{name: "set false", lookup: mockLookupEnv("FEATURE_X", "false"), expected: false},
+		{name: "set garbage", lookup: mockLookupEnv("FEATURE_X", "maybe"), expected: false},
+		{name: "unset", lookup: mockLookupEnv("FEATURE_Y", "true"), expected: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if enabled := featureEnabled(test.lookup, "FEATURE_X"); enabled != test.expected {
+				t.Errorf("expected %v got %v", test.expected, enabled)
+			}
+		})
+	}
+}
+
+func mockLookupEnvMap(values map[string]string) envLookup {
+	return func(key string) (string, bool) {
+		value, ok := values[key]
+		return value, ok
+	}
+}
+
+func TestLookupEnvFirst(t *testing.T) {
+	tests := []struct {
+		name          string
+		lookupFunc    envLookup
+		expectedValue string
+		expectedOk    bool
+	}{
+		{
+			name:          "first set",
+			lookupFunc:    mockLookupEnvMap(map[string]string{"APP_URL": "new", "LEGACY_URL": "legacy"}),
+			expectedValue: "new",
+			expectedOk:    true,
+		},
+		{
+			name:          "second set",
+			lookupFunc:    mockLookupEnvMap(map[string]string{"LEGACY_URL": "legacy"}),
+			expectedValue: "legacy",
+			expectedOk:    true,
+		},
+		{
+			name:          "none set",
+			lookupFunc:    mockLookupEnvMap(map[string]string{}),
+			expectedValue: "",
+			expectedOk:    false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, ok := lookupEnvFirst(tt.lookupFunc, "APP_URL", "LEGACY_URL")
+			if value != tt.expectedValue || ok != tt.expectedOk {
+				t.Fatalf("expected (%v, %v), got (%v, %v)", tt.expectedValue, tt.expectedOk, value, ok)
+			}
+		})
+	}
+}
+
+func TestLookupEnvFirstWithDefault(t *testing.T) {
+	lookup := mockLookupEnvMap(map[string]string{"LEGACY_URL": "legacy"})
+
+	if value := lookupEnvFirstWithDefault(lookup, "default", "APP_URL", "LEGACY_URL"); value != "legacy" {
+		t.Fatalf("expected legacy, got %v", value)
+	}
+
+	if value := lookupEnvFirstWithDefault(lookup, "default", "APP_URL"); value != "default" {
+		t.Fatalf("expected default, got %v", value)
+	}
+}
+
+func TestCoalesce(t *testing.T) {
+	lookup := mockLookupEnvMap(map[string]string{"APP_PORT": "8080"})
+	envPort := func(key string) func() (int, bool) {
+		return func() (int, bool) {
+			port, err := lookupEnvInt(lookup, key)
+			return port, err == nil
+		}
+	}
+
+	called := false
+	value, ok := Coalesce(
+		envPort("LEGACY_PORT"),
+		envPort("APP_PORT"),
+		func() (int, bool) {
+			called = true
+			return 80, true
+		},
+	)
+	if !ok || value != 8080 {
+		t.Fatalf("expected 8080, got %v (found %v)", value, ok)
+	}
+	if called {
+		t.Errorf("expected providers after the first found not to be called")
+	}
+
+	value, ok = Coalesce(envPort("LEGACY_PORT"), envPort("OTHER_PORT"))
+	if ok || value != 0 {
+		t.Fatalf("expected zero value and not found, got %v (found %v)", value, ok)
+	}
+
+	if _, ok := Coalesce[string](); ok {
+		t.Errorf("expected no providers to be not found")
+	}
+}
+
+func TestLookupEnvBase64(t *testing.T) {
+	data := []byte{0xfb, 0xff, 0xfe, 'h', 'i'}
+
+	tests := []struct {
+		name          string
+		key           string
+		lookupFunc    envLookup
+		expected      []byte
+		errorExpected bool
+	}{
+		{
+			name:       "std",
+			key:        "TEST_KEY",
+			lookupFunc: mockLookupEnv("TEST_KEY", base64.StdEncoding.EncodeToString(data)),
+			expected:   data,
+		},
+		{
+			name:       "url",
+			key:        "TEST_KEY",
+			lookupFunc: mockLookupEnv("TEST_KEY", base64.URLEncoding.EncodeToString(data)),
+			expected:   data,
+		},
+		{
+			name:       "url without padding",
+			key:        "TEST_KEY",
+			lookupFunc: mockLookupEnv("TEST_KEY", base64.RawURLEncoding.EncodeToString(data)),
+			expected:   data,
+		},
+		{
+			name:       "pem",
+			key:        "TEST_KEY",
+			lookupFunc: mockLookupEnv("TEST_KEY", base64.StdEncoding.EncodeToString([]byte("-----BEGIN KEY-----"))),
+			expected:   []byte("-----BEGIN KEY-----"),
+		},
+		{
+			name:          "unset",
+			key:           "TEST_KEY_NO_VALUE",
+			lookupFunc:    mockLookupEnv("TEST_KEY", "aGk="),
+			errorExpected: true,
+		},
+		{
+			name:          "malformed",
+			key:           "TEST_KEY",
+			lookupFunc:    mockLookupEnv("TEST_KEY", "not base64!"),
+			errorExpected: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := lookupEnvBase64(tt.lookupFunc, tt.key)
+			if tt.errorExpected {
+				if err == nil {
+					t.Fatalf("expected error, got %v", value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !bytes.Equal(value, tt.expected) {
+				t.Fatalf("expected %v, got %v", tt.expected, value)
+			}
+		})
+	}
+}
+
+func TestLookupEnvStringMap(t *testing.T) {
+	tests := []struct {
+		name          string
+		key           string
+		lookupFunc    envLookup
+		expected      map[string]string
+		errorExpected bool
+	}{
+		{
+			name:       "well formed",
+			key:        "LABELS",
+			lookupFunc: mockLookupEnv("LABELS", "env=prod, team = core ,empty="),
+			expected:   map[string]string{"env": "prod", "team": "core", "empty": ""},
+		},
+		{
+			name:       "trailing separator",
+			key:        "LABELS",
+			lookupFunc: mockLookupEnv("LABELS", "env=prod,"),
+			expected:   map[string]string{"env": "prod"},
+		},
+		{
+			name:          "malformed pair",
+			key:           "LABELS",
+			lookupFunc:    mockLookupEnv("LABELS", "env=prod,team"),
+			errorExpected: true,
+		},
+		{
+			name:          "unset",
+			key:           "LABELS",
+			lookupFunc:    mockLookupEnv("OTHER", "env=prod"),
+			errorExpected: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := lookupEnvStringMap(tt.lookupFunc, tt.key, ",", "=")
+			if tt.errorExpected {
+				if err == nil {
+					t.Fatalf("expected error, got %v", value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(value, tt.expected) {
+				t.Fatalf("expected %v, got %v", tt.expected, value)
+			}
+		})
+	}
+
+	_, err := lookupEnvStringMap(mockLookupEnv("OTHER", "env=prod"), "LABELS", ",", "=")
+	if !errors.Is(err, ErrEnvNotSet) {
+		t.Fatalf("expected ErrEnvNotSet, got %v", err)
+	}
+}
+
+func TestLookupEnvInt(t *testing.T) {
+	value, err := lookupEnvInt(mockLookupEnv("TEST_KEY", " 42 "), "TEST_KEY")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if value != 42 {
+		t.Fatalf("expected 42, got %v", value)
+	}
+
+	if _, err := lookupEnvInt(mockLookupEnv("TEST_KEY", "forty-two"), "TEST_KEY"); err == nil {
+		t.Fatalf("expected error for invalid value")
+	}
+
+	if _, err := lookupEnvInt(mockLookupEnv("TEST_KEY", "42"), "TEST_KEY_NO_VALUE"); !errors.Is(err, ErrEnvNotSet) {
+		t.Fatalf("expected ErrEnvNotSet, got %v", err)
+	}
+}
+
+func TestLookupEnvTime(t *testing.T) {
+	tests := []struct {
+		name          string
+		value         string
+		layout        string
+		expected      time.Time
+		errorExpected bool
+	}{
+		{name: "rfc3339 default layout", value: "2024-03-01T12:30:00Z", expected: time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)},
+		{name: "rfc3339 offset", value: "2024-03-01T12:30:00+01:00", layout: time.RFC3339, expected: time.Date(2024, 3, 1, 11, 30, 0, 0, time.UTC)},
+		{name: "custom layout", value: " 2024-03-01 ", layout: time.DateOnly, expected: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{name: "unparseable", value: "yesterday", errorExpected: true},
+		{name: "wrong layout", value: "2024-03-01", layout: time.RFC3339, errorExpected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := lookupEnvTime(mockLookupEnv("TEST_KEY", tt.value), "TEST_KEY", tt.layout)
+			if tt.errorExpected {
+				if err == nil {
+					t.Errorf("expected error")
+				}
+				if errors.Is(err, ErrEnvNotSet) {
+					t.Errorf("expected parse error got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !value.Equal(tt.expected) {
+				t.Errorf("expected '%s' got '%s'", tt.expected, value)
+			}
+		})
+	}
+
+	if _, err := lookupEnvTime(mockLookupEnv("TEST_KEY", "2024-03-01T12:30:00Z"), "TEST_KEY_NO_VALUE", ""); !errors.Is(err, ErrEnvNotSet) {
+		t.Errorf("expected ErrEnvNotSet, got %v", err)
+	}
+}
+
+func TestLookupEnvTimeWithDefault(t *testing.T) {
+	defaultValue := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	value, err := lookupEnvTimeWithDefault(mockLookupEnv("TEST_KEY", "2024-03-01T12:30:00Z"), "TEST_KEY_NO_VALUE", "", defaultValue)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !value.Equal(defaultValue) {
+		t.Errorf("expected '%s' got '%s'", defaultValue, value)
+	}
+
+	value, err = lookupEnvTimeWithDefault(mockLookupEnv("TEST_KEY", "2024-03-01T12:30:00Z"), "TEST_KEY", "", defaultValue)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC); !value.Equal(expected) {
+		t.Errorf("expected '%s' got '%s'", expected, value)
+	}
+
+	if _, err := lookupEnvTimeWithDefault(mockLookupEnv("TEST_KEY", "soon"), "TEST_KEY", "", defaultValue); err == nil {
+		t.Errorf("expected error for unparseable value")
+	}
+}
+
+type testFormat int
+
+const (
+	testFormatText testFormat = iota
+	testFormatJSON
+	testFormatYAML
+)
+
+func TestLookupEnvMapped(t *testing.T) {
+	mapping := map[string]testFormat{
+		"text": testFormatText,
+		"json": testFormatJSON,
+		"yaml": testFormatYAML,
+	}
+
+	tests := []struct {
+		name          string
+		lookupFunc    envLookup
+		expected      testFormat
+		expectedErr   error
+		errorContains string
+	}{
+		{name: "json", lookupFunc: mockLookupEnv("TEST_KEY", "json"), expected: testFormatJSON},
+		{name: "yaml with whitespace", lookupFunc: mockLookupEnv("TEST_KEY", " yaml "), expected: testFormatYAML},
+		{name: "zero value mapping", lookupFunc: mockLookupEnv("TEST_KEY", "text"), expected: testFormatText},
+		{name: "unknown", lookupFunc: mockLookupEnv("TEST_KEY", "toml"), errorContains: "json, text, yaml"},
+		{name: "case sensitive", lookupFunc: mockLookupEnv("TEST_KEY", "JSON"), errorContains: "expected one of"},
+		{name: "not set", lookupFunc: mockLookupEnv("OTHER_KEY", "json"), expectedErr: ErrEnvNotSet},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := lookupEnvMapped(tt.lookupFunc, "TEST_KEY", mapping)
+			if tt.expectedErr != nil {
+				if !errors.Is(err, tt.expectedErr) {
+					t.Errorf("expected '%v' got '%v'", tt.expectedErr, err)
+				}
+				return
+			}
+			if tt.errorContains != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errorContains) {
+					t.Errorf("expected error containing '%s' got '%v'", tt.errorContains, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if value != tt.expected {
+				t.Errorf("expected %d got %d", tt.expected, value)
+			}
+		})
+	}
+}
+
+func expectPanic(t *testing.T, name string, f func()) {
+	t.Helper()
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("%v: expected panic", name)
+		}
+	}()
+	f()
+}
+
+func TestMustLookupEnv(t *testing.T) {
+	t.Setenv("TEST_MUST_STRING", "value")
+	t.Setenv("TEST_MUST_INT", "42")
+	t.Setenv("TEST_MUST_BOOL", "True")
+	t.Setenv("TEST_MUST_BOOL_FALSE", "false")
+	t.Setenv("TEST_MUST_BOOL_LENIENT", "yes")
+	t.Setenv("TEST_MUST_URL", "https://asdf/asdf")
+	t.Setenv("TEST_MUST_BASE64", "aGk=")
+	t.Setenv("TEST_MUST_INVALID", "asdf\nasdf!")
+
+	if value := MustLookupEnv("TEST_MUST_STRING"); value != "value" {
+		t.Errorf("expected value, got %v", value)
+	}
+	if value := MustLookupEnvInt("TEST_MUST_INT"); value != 42 {
+		t.Errorf("expected 42, got %v", value)
+	}
+	if value := MustLookupEnvBool("TEST_MUST_BOOL"); !value {
+		t.Errorf("expected true, got %v", value)
+	}
+	if value := MustLookupEnvBool("TEST_MUST_BOOL_FALSE"); value {
+		t.Errorf("expected false, got %v", value)
+	}
+	if value := MustLookupEnvURL("TEST_MUST_URL"); value.String() != "https://asdf/asdf" {
+		t.Errorf("expected https://asdf/asdf, got %v", value)
+	}
+	if value := MustLookupEnvBase64("TEST_MUST_BASE64"); string(value) != "hi" {
+		t.Errorf("expected hi, got %v", value)
+	}
+
+	expectPanic(t, "missing string", func() { MustLookupEnv("TEST_MUST_MISSING") })
+	expectPanic(t, "missing int", func() { MustLookupEnvInt("TEST_MUST_MISSING") })
+	expectPanic(t, "invalid int", func() { MustLookupEnvInt("TEST_MUST_STRING") })
+	expectPanic(t, "missing bool", func() { MustLookupEnvBool("TEST_MUST_MISSING") })
+	expectPanic(t, "invalid bool", func() { MustLookupEnvBool("TEST_MUST_STRING") })
+	expectPanic(t, "lenient bool", func() { MustLookupEnvBool("TEST_MUST_BOOL_LENIENT") })
+	expectPanic(t, "missing url", func() { MustLookupEnvURL("TEST_MUST_MISSING") })
+	expectPanic(t, "invalid url", func() { MustLookupEnvURL("TEST_MUST_INVALID") })
+	expectPanic(t, "missing base64", func() { MustLookupEnvBase64("TEST_MUST_MISSING") })
+	expectPanic(t, "invalid base64", func() { MustLookupEnvBase64("TEST_MUST_INVALID") })
+}
+
+func TestLookupEnvURLSlice(t *testing.T) {
+	tests := []struct {
+		name          string
+		key           string
+		lookupFunc    envLookup
+		expected      []string
+		errorExpected bool
+	}{
+		{
+			name:       "space separated",
+			key:        "UPSTREAMS",
+			lookupFunc: mockLookupEnv("UPSTREAMS", "https://a https://b"),
+			expected:   []string{"https://a", "https://b"},
+		},
+		{
+			name:       "comma and whitespace separated",
+			key:        "UPSTREAMS",
+			lookupFunc: mockLookupEnv("UPSTREAMS", " https://a,https://b ,\n https://c:8443/path "),
+			expected:   []string{"https://a", "https://b", "https://c:8443/path"},
+		},
+		{
+			name:          "invalid element",
+			key:           "UPSTREAMS",
+			lookupFunc:    mockLookupEnv("UPSTREAMS", "https://a ht%tp://b"),
+			errorExpected: true,
+		},
+		{
+			name:          "unset",
+			key:           "UPSTREAMS",
+			lookupFunc:    mockLookupEnv("OTHER", "https://a"),
+			errorExpected: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := lookupEnvURLSlice(tt.lookupFunc, tt.key)
+			if tt.errorExpected {
+				if err == nil {
+					t.Fatalf("expected error, got %v", value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(value) != len(tt.expected) {
+				t.Fatalf("expected %v, got %v", tt.expected, value)
+			}
+			for i, u := range value {
+				if u.String() != tt.expected[i] {
+					t.Errorf("expected %v, got %v", tt.expected[i], u)
+				}
+			}
+		})
+	}
+
+	_, err := lookupEnvURLSlice(mockLookupEnv("UPSTREAMS", "https://a ht%tp://b"), "UPSTREAMS")
+	if err == nil || !strings.Contains(err.Error(), "ht%tp://b") {
+		t.Errorf("expected error naming the invalid element, got %v", err)
+	}
+}
+
+func TestEnv(t *testing.T) {
+	e := NewEnv("SVCA")
+	e.lookup = mockLookupEnvMap(map[string]string{
+		"SVCA_PORT":     "8080",
+		"SVCB_PORT":     "9090",
+		"SVCA_DEBUG":    "true",
+		"SVCA_ENABLED":  "yes",
+		"SVCA_URL":      "https://a",
+		"SVCA_UPSTREAM": "https://a,https://b",
+		"SVCA_KEY":      "aGk=",
+		"SVCA_LABELS":   "env=prod",
+		"PORT":          "1",
+	})
+
+	if key := e.Key("PORT"); key != "SVCA_PORT" {
+		t.Errorf("expected SVCA_PORT got %v", key)
+	}
+	if port, err := e.Int("PORT"); err != nil || port != 8080 {
+		t.Errorf("expected 8080 got %v (%v)", port, err)
+	}
+	if value, ok := e.Lookup("PORT"); !ok || value != "8080" {
+		t.Errorf("expected 8080 got %v", value)
+	}
+	if value := e.WithDefault("MISSING", "default"); value != "default" {
+		t.Errorf("expected default got %v", value)
+	}
+	if !e.Bool("DEBUG") {
+		t.Errorf("expected DEBUG to be true")
+	}
+	if enabled, err := e.BoolLenient("ENABLED"); err != nil || !enabled {
+		t.Errorf("expected ENABLED to be true (%v)", err)
+	}
+	if u, err := e.URL("URL"); err != nil || u.String() != "https://a" {
+		t.Errorf("expected https://a got %v (%v)", u, err)
+	}
+	if urls, err := e.URLSlice("UPSTREAM"); err != nil || len(urls) != 2 {
+		t.Errorf("expected 2 urls got %v (%v)", urls, err)
+	}
+	if data, err := e.Base64("KEY"); err != nil || string(data) != "hi" {
+		t.Errorf("expected hi got %v (%v)", data, err)
+	}
+	if labels, err := e.StringMap("LABELS", ",", "="); err != nil || labels["env"] != "prod" {
+		t.Errorf("expected env=prod got %v (%v)", labels, err)
+	}
+	if _, err := e.Int("MISSING"); !errors.Is(err, ErrEnvNotSet) {
+		t.Errorf("expected ErrEnvNotSet got %v", err)
+	}
+}
+
+func TestEnvNoPrefix(t *testing.T) {
+	t.Setenv("TEST_ENV_NO_PREFIX", "42")
+
+	if value, err := NewEnv("").Int("TEST_ENV_NO_PREFIX"); err != nil || value != 42 {
+		t.Errorf("expected 42 got %v (%v)", value, err)
+	}
+}
+
+func TestSplitEnvEntry(t *testing.T) {
+	tests := []struct {
+		entry string
+		key   string
+		value string
+	}{
+		{entry: "KEY=value", key: "KEY", value: "value"},
+		{entry: "KEY=a=b", key: "KEY", value: "a=b"},
+		{entry: "KEY=", key: "KEY", value: ""},
+		{entry: "=C:=C:\\", key: "=C:", value: "C:\\"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.entry, func(t *testing.T) {
+			key, value := splitEnvEntry(tt.entry)
+			if key != tt.key || value != tt.value {
+				t.Errorf("expected '%s'='%s' got '%s'='%s'", tt.key, tt.value, key, value)
+			}
+		})
+	}
+}
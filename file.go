@@ -1,12 +1,20 @@
 package util
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/mitchellh/go-homedir"
@@ -37,185 +45,1825 @@ func CleanOpenFile(path string, flag int, perm os.FileMode) (*os.File, error) {
 	return os.OpenFile(cleanPath, flag, perm) // #nosec
 }
 
+// CleanCreate creates or truncates a file for writing, creating its parent directory if it doesn't exist.
+func CleanCreate(path string) (*os.File, error) {
+	_, err := EnsureParentDir(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create directory path: %w", err)
+	}
+
+	return CleanOpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+}
+
+// CleanOpenAppend opens a file for appending, creating it with perm and its parent directory if they don't exist.
+func CleanOpenAppend(path string, perm os.FileMode) (*os.File, error) {
+	_, err := EnsureParentDir(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create directory path: %w", err)
+	}
+
+	return CleanOpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, perm)
+}
+
+// gzipMagic is the header that starts every gzip stream
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// readCloser reads from r and closes each of closers, in order, on Close
+type readCloser struct {
+	r       io.Reader
+	closers []io.Closer
+}
+
+func (rc *readCloser) Read(p []byte) (int, error) {
+	return rc.r.Read(p)
+}
+
+func (rc *readCloser) Close() error {
+	var err error
+	for _, c := range rc.closers {
+		if closeErr := c.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// CleanOpenMaybeGzip opens a file for reading like CleanOpen, transparently decompressing it if the path ends
+// in .gz or the content starts with the gzip header. Closing the returned reader closes the file.
+func CleanOpenMaybeGzip(path string) (io.ReadCloser, error) {
+	f, err := CleanOpen(path)
+	if err != nil {
+		return nil, err
+	}
+
+	buffered := bufio.NewReader(f)
+	header, err := buffered.Peek(len(gzipMagic))
+	if err != nil && !errors.Is(err, io.EOF) {
+		closeErr := f.Close()
+		if closeErr != nil {
+			return nil, fmt.Errorf("%w: %v", err, closeErr)
+		}
+		return nil, err
+	}
+
+	if !strings.HasSuffix(strings.ToLower(path), ".gz") && !bytes.Equal(header, gzipMagic) {
+		return &readCloser{r: buffered, closers: []io.Closer{f}}, nil
+	}
+
+	gz, err := gzip.NewReader(buffered)
+	if err != nil {
+		closeErr := f.Close()
+		if closeErr != nil {
+			return nil, fmt.Errorf("%w: %v", err, closeErr)
+		}
+		return nil, err
+	}
+
+	return &readCloser{r: gz, closers: []io.Closer{gz, f}}, nil
+}
+
+// SecureDelete overwrites the (expanded) file's contents with zeros, syncs it to disk and then removes it, to
+// reduce the chance of a secret being recovered. This is best-effort only: copy-on-write and log-structured
+// filesystems, SSD wear levelling, snapshots and backups may all retain the original contents.
+// Symlinks are refused rather than followed, so a link can't be used to wipe its target. The data is shared by
+// any other hard links to the file, which are left in place and will read as zeros.
+func SecureDelete(path string) error {
+	expandedPath, err := ExpandPath(path)
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Lstat(expandedPath)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%v is not a regular file", expandedPath)
+	}
+
+	f, err := CleanOpenFile(expandedPath, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+
+	err = overwriteWithZeros(f, info)
+	if err != nil {
+		closeErr := f.Close()
+		if closeErr != nil {
+			return fmt.Errorf("%w: %v", err, closeErr)
+		}
+		return err
+	}
+
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(expandedPath)
+}
+
+// overwriteWithZeros replaces the whole length of f with zeros in place and syncs it. It refuses if f is no longer
+// the file described by expected, e.g. because the path was replaced with a symlink after it was checked.
+func overwriteWithZeros(f *os.File, expected os.FileInfo) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if !os.SameFile(info, expected) {
+		return fmt.Errorf("%v changed while opening", f.Name())
+	}
+
+	zeros := make([]byte, 32*1024)
+	for remaining := info.Size(); remaining > 0; {
+		n, err := f.Write(zeros[:min(remaining, int64(len(zeros)))])
+		if err != nil {
+			return err
+		}
+		remaining -= int64(n)
+	}
+
+	return f.Sync()
+}
+
+// CleanWalk walks the file tree rooted at the (expanded) root, calling fn for each file or directory
+// as filepath.WalkDir does.
+func CleanWalk(root string, fn fs.WalkDirFunc) error {
+	expandedRoot, err := ExpandPath(root)
+	if err != nil {
+		return err
+	}
+
+	return filepath.WalkDir(expandedRoot, fn)
+}
+
+// ErrPathEscapesRoot is returned when a path resolves to a location outside of its permitted root
+var ErrPathEscapesRoot = errors.New("path escapes root")
+
+// isWithinDir reports whether path is dir or is contained within it, both must be clean absolute paths
+func isWithinDir(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
+
+// resolveWithinRoot expands root and path, resolving path against root if it is relative, then evaluates
+// symlinks in both and returns the resolved path if it is contained within root.
+func resolveWithinRoot(root, path string) (string, error) {
+	expandedRoot, err := ExpandPath(root)
+	if err != nil {
+		return "", err
+	}
+
+	expandedPath, err := homedir.Expand(path)
+	if err != nil {
+		return "", err
+	}
+	expandedPath = os.ExpandEnv(expandedPath)
+	if !filepath.IsAbs(expandedPath) {
+		expandedPath = filepath.Join(expandedRoot, expandedPath)
+	}
+	expandedPath = filepath.Clean(expandedPath)
+
+	resolvedRoot, err := filepath.EvalSymlinks(expandedRoot)
+	if err != nil {
+		return "", err
+	}
+
+	resolvedPath, err := filepath.EvalSymlinks(expandedPath)
+	if err != nil {
+		return "", err
+	}
+
+	if !isWithinDir(resolvedRoot, resolvedPath) {
+		return "", fmt.Errorf("%w: %v resolves outside %v", ErrPathEscapesRoot, path, root)
+	}
+
+	return resolvedPath, nil
+}
+
+// OpenWithinRoot opens path for reading, refusing with ErrPathEscapesRoot if it, after expansion and resolving
+// symlinks, is outside root. Relative paths are resolved against root.
+func OpenWithinRoot(root, path string) (*os.File, error) {
+	resolvedPath, err := resolveWithinRoot(root, path)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.Open(resolvedPath) // #nosec
+}
+
+// OpenRelative opens relPath resolved against the directory containing baseFile, e.g. a file referenced by a
+// config file, returning ErrPathEscapesRoot if it resolves outside that directory.
+func OpenRelative(baseFile, relPath string) (*os.File, error) {
+	expandedBase, err := ExpandPath(baseFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return OpenWithinRoot(filepath.Dir(expandedBase), relPath)
+}
+
 // CreateDirPath creates a directory path if it doesn't exist.
 func CreateDirPath(path string, defaultPath string) (string, error) {
 	if path == "" {
 		path = defaultPath
 	}
 
-	path, err := ExpandPath(path)
+	path, err := ExpandPath(path)
+	if err != nil {
+		return "", err
+	}
+
+	err = os.MkdirAll(path, 0750)
+	if err != nil {
+		return "", err
+	}
+
+	return path, nil
+}
+
+// CreateDirIfParentExists creates the (expanded) directory path, only if its parent directory already exists,
+// to avoid creating a whole tree from a mistyped path. An existing directory at path is not an error.
+// It returns the expanded path.
+func CreateDirIfParentExists(path string) (string, error) {
+	path, err := ExpandPath(path)
+	if err != nil {
+		return "", err
+	}
+
+	parent := filepath.Dir(path)
+	info, err := os.Stat(parent)
+	if err != nil {
+		return "", fmt.Errorf("parent directory %v: %w", parent, err)
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("parent %v is not a directory", parent)
+	}
+
+	err = os.Mkdir(path, 0750)
+	if errors.Is(err, fs.ErrExist) {
+		info, statErr := os.Stat(path)
+		if statErr == nil && info.IsDir() {
+			return path, nil
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return path, nil
+}
+
+// EnsureParentDir creates the parent directory of a file path if it doesn't exist.
+// It returns the (expanded) parent directory.
+func EnsureParentDir(filePath string) (string, error) {
+	path, err := ExpandPath(filePath)
+	if err != nil {
+		return "", err
+	}
+
+	return CreateDirPath(filepath.Dir(path), "")
+}
+
+// IsWritable reports whether the process can write to the (expanded) path. For directories it creates and
+// immediately removes a temporary file inside it, for files it opens the file for writing without modifying it.
+// A permission error is reported as false with a nil error, other errors (e.g. not found) are returned.
+func IsWritable(path string) (bool, error) {
+	expandedPath, err := ExpandPath(path)
+	if err != nil {
+		return false, err
+	}
+
+	info, err := os.Stat(expandedPath)
+	if err != nil {
+		return false, err
+	}
+
+	var f *os.File
+	if info.IsDir() {
+		f, err = os.CreateTemp(expandedPath, ".writable-*")
+	} else {
+		f, err = os.OpenFile(expandedPath, os.O_WRONLY|os.O_APPEND, 0) // #nosec
+	}
+	if errors.Is(err, fs.ErrPermission) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	err = f.Close()
+	if info.IsDir() {
+		removeErr := os.Remove(f.Name())
+		if err == nil {
+			err = removeErr
+		}
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// ExpandPath expands a path to an absolute path.
+// It also expands ~ and environment variables.
+func ExpandPath(path string) (string, error) {
+	path, err := homedir.Expand(path)
+	if err != nil {
+		return "", err
+	}
+
+	path = os.ExpandEnv(path)
+
+	path = filepath.Clean(path)
+
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+
+	return path, nil
+}
+
+// PrettyPath expands path with ExpandPath and, if the result is within the user's home directory, abbreviates the
+// home directory to "~" for display, e.g. "/home/user/app/config.yaml" becomes "~/app/config.yaml".
+func PrettyPath(path string) (string, error) {
+	expandedPath, err := ExpandPath(path)
+	if err != nil {
+		return "", err
+	}
+
+	home, err := homedir.Dir()
+	if err != nil {
+		return expandedPath, nil
+	}
+	home = filepath.Clean(home)
+
+	if !isWithinDir(home, expandedPath) {
+		return expandedPath, nil
+	}
+
+	rel, err := filepath.Rel(home, expandedPath)
+	if err != nil {
+		return expandedPath, nil
+	}
+	if rel == "." {
+		return "~", nil
+	}
+	return "~" + string(filepath.Separator) + rel, nil
+}
+
+// ExpandPathSplit expands path with ExpandPath and returns the directory and base name of the result, so both
+// reflect a single expansion.
+func ExpandPathSplit(path string) (dir, file string, err error) {
+	expandedPath, err := ExpandPath(path)
+	if err != nil {
+		return "", "", err
+	}
+	return filepath.Dir(expandedPath), filepath.Base(expandedPath), nil
+}
+
+// DefaultConfigPath returns the absolute path of fileName within the appName directory of the user's config
+// directory, e.g. "~/.config/appname/config.yaml". os.UserConfigDir is used, falling back to "~/.config"
+// when it can't be determined.
+func DefaultConfigPath(appName, fileName string) (string, error) {
+	if appName == "" || fileName == "" {
+		return "", fmt.Errorf("app name and file name must not be empty")
+	}
+
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		configDir = filepath.Join("~", ".config")
+	}
+
+	return ExpandPath(filepath.Join(configDir, appName, fileName))
+}
+
+// ExpandStructPaths expands, using ExpandPath, the string, *string and []string fields tagged `path:"true"`
+// of the struct pointed to by v. Nested structs, pointers to structs and slices of structs are walked.
+func ExpandStructPaths(v any) error {
+	value := reflect.ValueOf(v)
+	if value.Kind() != reflect.Ptr || value.IsNil() {
+		return fmt.Errorf("expected non-nil pointer to struct, got %T", v)
+	}
+	return expandStructPaths(value)
+}
+
+func expandStructPaths(v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return expandStructPaths(v.Elem())
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := expandStructPaths(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Field(i)
+			if !field.CanSet() {
+				continue
+			}
+
+			var err error
+			if v.Type().Field(i).Tag.Get("path") == "true" {
+				err = expandPathField(field)
+			} else {
+				err = expandStructPaths(field)
+			}
+			if err != nil {
+				return fmt.Errorf("%v: %w", v.Type().Field(i).Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func expandPathField(field reflect.Value) error {
+	switch {
+	case field.Kind() == reflect.String:
+		if field.String() == "" {
+			return nil
+		}
+		path, err := ExpandPath(field.String())
+		if err != nil {
+			return err
+		}
+		field.SetString(path)
+	case field.Kind() == reflect.Ptr && field.Type().Elem().Kind() == reflect.String:
+		if field.IsNil() {
+			return nil
+		}
+		return expandPathField(field.Elem())
+	case field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.String:
+		for i := 0; i < field.Len(); i++ {
+			if err := expandPathField(field.Index(i)); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("path tag is only supported on string, *string and []string fields, not %v", field.Type())
+	}
+	return nil
+}
+
+// WaitForFiles waits for a set of files to exist, it will check every interval seconds up until max seconds.
+func WaitForFiles(interval, max uint, files ...string) error {
+	i := time.Duration(interval) * time.Second
+	return WaitFor(i, max, func() bool {
+		return FilesExist(files...)
+	})
+}
+
+type statFunc func(string) (os.FileInfo, error)
+
+// statContext runs stat in a goroutine so that a slow stat (e.g. on a network filesystem)
+// doesn't prevent returning promptly with ctx.Err() when ctx is done.
+func statContext(ctx context.Context, stat statFunc, path string) error {
+	result := make(chan error, 1)
+	go func() {
+		_, err := stat(path)
+		result <- err
+	}()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-result:
+		return err
+	}
+}
+
+func waitForFile(ctx context.Context, c clock, stat statFunc, interval time.Duration, maxTries uint, path string) error {
+	expandedPath, err := ExpandPath(path)
+	if err != nil {
+		return err
+	}
+
+	err = waitUntil(ctx, c, interval, maxTries, func() bool {
+		return statContext(ctx, stat, expandedPath) == nil
+	})
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	if err != nil {
+		return fmt.Errorf("file not found: %v", expandedPath)
+	}
+	return nil
+}
+
+// WaitForFile waits for a file to exist, checking every interval up to maxTries times.
+// It returns ctx.Err() promptly if ctx is done, even while a slow stat is in progress.
+func WaitForFile(ctx context.Context, interval time.Duration, maxTries uint, path string) error {
+	return waitForFile(ctx, realClock{}, os.Stat, interval, maxTries, path)
+}
+
+// WaitForFilesDetailed waits for a set of files to exist, checking every interval up to maxTries times.
+// On success it returns an empty slice, otherwise it returns the files that never appeared
+// and an error naming them (or ctx.Err() if ctx is done first).
+func WaitForFilesDetailed(ctx context.Context, interval time.Duration, maxTries uint, files ...string) ([]string, error) {
+	missing := files
+	err := waitUntil(ctx, realClock{}, interval, maxTries, func() bool {
+		missing = missingFiles(files...)
+		return len(missing) == 0
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return missing, err
+		}
+		return missing, fmt.Errorf("files not found: %v", strings.Join(missing, ", "))
+	}
+	return []string{}, nil
+}
+
+func waitForFilesStrict(ctx context.Context, c clock, stat statFunc, interval time.Duration, maxTries uint, files ...string) error {
+	missing := files
+	var statErr error
+	err := waitUntil(ctx, c, interval, maxTries, func() bool {
+		missing, statErr = missingFilesStrict(stat, files...)
+		return statErr != nil || len(missing) == 0
+	})
+	if statErr != nil {
+		return statErr
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return err
+		}
+		return fmt.Errorf("files not found: %v", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// WaitForFilesStrict waits for a set of files to exist, checking every interval up to maxTries times.
+// Unlike WaitForFiles only files that don't exist are retried, any other stat error, such as a permission
+// error, is returned immediately.
+func WaitForFilesStrict(ctx context.Context, interval time.Duration, maxTries uint, files ...string) error {
+	return waitForFilesStrict(ctx, realClock{}, os.Stat, interval, maxTries, files...)
+}
+
+// missingFiles returns the file names that don't exist
+func missingFiles(files ...string) []string {
+	missing := make([]string, 0)
+	for _, f := range files {
+		if fileExists(f) != nil {
+			missing = append(missing, f)
+		}
+	}
+	return missing
+}
+
+func fileExists(filename string) error {
+	_, err := os.Stat(filename)
+	return err
+}
+
+// FilesExist checks if all file names exist.
+func FilesExist(files ...string) bool {
+	return generics.Apply(fileExists, files) == nil
+}
+
+// missingFilesStrict returns the file names that don't exist, or the first stat error that isn't a
+// not-exist error, e.g. a permission error
+func missingFilesStrict(stat statFunc, files ...string) ([]string, error) {
+	missing := make([]string, 0)
+	for _, f := range files {
+		_, err := stat(f)
+		if errors.Is(err, fs.ErrNotExist) {
+			missing = append(missing, f)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return missing, nil
+}
+
+// FilesExistStrict checks if all file names exist, returning an error rather than false when a file can't be
+// stat'd for a reason other than not existing, e.g. a permission error.
+func FilesExistStrict(files ...string) (bool, error) {
+	missing, err := missingFilesStrict(os.Stat, files...)
+	if err != nil {
+		return false, err
+	}
+	return len(missing) == 0, nil
+}
+
+// FirstExistingFile returns the first of the (expanded) paths that exists.
+// It returns "" and false if none of the paths exist.
+func FirstExistingFile(paths ...string) (string, bool) {
+	for _, path := range paths {
+		expandedPath, err := ExpandPath(path)
+		if err != nil {
+			continue
+		}
+		if fileExists(expandedPath) == nil {
+			return expandedPath, true
+		}
+	}
+	return "", false
+}
+
+// ResolveExistingFiles expands each path with ExpandPath and returns the expanded paths, in order.
+// It returns an error naming every path that doesn't exist after expansion.
+func ResolveExistingFiles(paths ...string) ([]string, error) {
+	expandedPaths := make([]string, 0, len(paths))
+	for _, path := range paths {
+		expandedPath, err := ExpandPath(path)
+		if err != nil {
+			return nil, err
+		}
+		expandedPaths = append(expandedPaths, expandedPath)
+	}
+
+	if missing := missingFiles(expandedPaths...); len(missing) > 0 {
+		return nil, fmt.Errorf("files not found: %v", strings.Join(missing, ", "))
+	}
+
+	return expandedPaths, nil
+}
+
+// NewestMatchingFile returns the most recently modified file matching the (expanded) glob pattern, e.g. the
+// current log file matching "~/logs/app-*.log". It returns an error if nothing matches.
+func NewestMatchingFile(pattern string) (string, error) {
+	expandedPattern, err := ExpandPath(pattern)
+	if err != nil {
+		return "", err
+	}
+
+	matches, err := filepath.Glob(expandedPattern)
+	if err != nil {
+		return "", err
+	}
+
+	newest := ""
+	var newestModTime time.Time
+	for _, match := range matches {
+		info, err := os.Stat(match)
+		if err != nil {
+			return "", err
+		}
+		if newest == "" || info.ModTime().After(newestModTime) {
+			newest = match
+			newestModTime = info.ModTime()
+		}
+	}
+
+	if newest == "" {
+		return "", fmt.Errorf("no files match %v", expandedPattern)
+	}
+
+	return newest, nil
+}
+
+// IsStale reports whether the (expanded) output is missing or older than any of the (expanded) inputs.
+// An error is returned if an input can't be stat'd.
+func IsStale(outputPath string, inputPaths ...string) (bool, error) {
+	expandedOutput, err := ExpandPath(outputPath)
+	if err != nil {
+		return false, err
+	}
+
+	outputInfo, err := os.Stat(expandedOutput)
+	if errors.Is(err, fs.ErrNotExist) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	for _, inputPath := range inputPaths {
+		expandedInput, err := ExpandPath(inputPath)
+		if err != nil {
+			return false, err
+		}
+
+		inputInfo, err := os.Stat(expandedInput)
+		if err != nil {
+			return false, err
+		}
+
+		if inputInfo.ModTime().After(outputInfo.ModTime()) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// ErrFileExceedsLimit is returned when a file is larger than the permitted number of bytes
+var ErrFileExceedsLimit = errors.New("file exceeds limit")
+
+// sizeLimitReader reads at most remaining bytes from r, returning ErrFileExceedsLimit
+// if there is more data available beyond the limit.
+type sizeLimitReader struct {
+	r         io.Reader
+	remaining int64
+	exceeded  bool
+}
+
+func (l *sizeLimitReader) Read(p []byte) (int, error) {
+	if l.remaining <= 0 {
+		n, err := l.r.Read(make([]byte, 1))
+		if n > 0 {
+			l.exceeded = true
+			return 0, ErrFileExceedsLimit
+		}
+		return 0, err
+	}
+
+	if int64(len(p)) > l.remaining {
+		p = p[:l.remaining]
+	}
+
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	return n, err
+}
+
+type decoder interface {
+	Decode(v interface{}) error
+}
+
+type encoder interface {
+	Encode(v interface{}) error
+}
+
+type decoderFunc func(r io.Reader) decoder
+type encoderFunc func(w io.Writer) encoder
+
+func yamlDecoderFunc(r io.Reader) decoder {
+	return yaml.NewDecoder(r)
+}
+
+func yamlEncoderFunc(w io.Writer) encoder {
+	return yaml.NewEncoder(w)
+}
+
+func jsonDecoderFunc(r io.Reader) decoder {
+	return json.NewDecoder(r)
+}
+
+func jsonEncoderFunc(w io.Writer) encoder {
+	return json.NewEncoder(w)
+}
+
+// formatFromFilePath returns the format named by a path's extension, case-insensitively
+func formatFromFilePath(path string) string {
+	return strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+}
+
+func encoderFuncFromFormat(format string) encoderFunc {
+	switch strings.ToLower(format) {
+	case "yaml", "yml":
+		return yamlEncoderFunc
+	case "json":
+		return jsonEncoderFunc
+	case "jsonl", "ndjson":
+		return jsonLinesEncoderFunc
+	default:
+		return nil
+	}
+}
+
+func encoderFuncFromFilePath(path string) encoderFunc {
+	return encoderFuncFromFormat(formatFromFilePath(path))
+}
+
+func decoderFuncFromFilePath(path string) decoderFunc {
+	return decoderFuncFromFormat(formatFromFilePath(path))
+}
+
+func decoderFuncFromFormat(format string) decoderFunc {
+	switch strings.ToLower(format) {
+	case "yaml", "yml":
+		return yamlDecoderFunc
+	case "json":
+		return jsonDecoderFunc
+	case "jsonl", "ndjson":
+		return jsonLinesDecoderFunc
+	default:
+		return nil
+	}
+}
+
+// SupportedFormats returns the config file formats, and so file extensions, that can be loaded and saved. JSON
+// Lines (jsonl/ndjson) files hold one record per line, so are loaded into and saved from slices.
+func SupportedFormats() []string {
+	return []string{"json", "yaml", "yml", "jsonl", "ndjson"}
+}
+
+// IsSupportedConfigFile reports whether path has a supported config file extension, compared case-insensitively,
+// without accessing the file.
+func IsSupportedConfigFile(path string) bool {
+	return decoderFuncFromFilePath(path) != nil
+}
+
+func saveStructToWriterWithEncoder[T any](v *T, w io.Writer, eFunc encoderFunc) error {
+	encoder := eFunc(w)
+	return encoder.Encode(v)
+}
+
+// IsZero reports whether v is the zero value of its type, using the same check the struct loaders use to
+// decide that nothing was loaded. Values are compared deeply, so an empty but non-nil slice or map is not zero.
+func IsZero[T any](v T) bool {
+	return generics.IsZeroValue(v)
+}
+
+func loadStructFromReaderWithDecoder[T any](r io.Reader, dFunc decoderFunc) (*T, error) {
+	var data T
+
+	encoder := dFunc(r)
+	err := encoder.Decode(&data)
+	if err != nil {
+		return nil, err
+	}
+
+	if IsZero(data) {
+		return nil, fmt.Errorf("failed to load data from file")
+	}
+
+	return &data, nil
+}
+
+func loadStructFromReaderWithFormat[T any](r io.Reader, format string) (*T, error) {
+	decFunc := decoderFuncFromFormat(format)
+
+	if decFunc == nil {
+		return nil, fmt.Errorf("unrecognised format %v. expected yaml/yml, json or jsonl/ndjson", format)
+	}
+
+	return loadStructFromReaderWithDecoder[T](r, decFunc)
+}
+
+// LoadStructFromStdin loads a struct from os.Stdin using the given format (yaml/yml or json).
+func LoadStructFromStdin[T any](format string) (*T, error) {
+	return loadStructFromReaderWithFormat[T](os.Stdin, format)
+}
+
+// LoadStructFromFile loads a struct from a yaml/yml or json file, the format is inferred from the file extension.
+// A path of "-" reads from os.Stdin, decoded as json if it starts with '{' or '[' and as yaml otherwise, use
+// LoadStructFromStdin to choose the format explicitly.
+func LoadStructFromFile[T any](filePath string) (*T, error) {
+	return loadStructFromFile[T](filePath, 0)
+}
+
+// LoadStructFromFileInto decodes a yaml/yml or json file into an existing struct, overwriting only the fields
+// present in the file. An empty file leaves v unchanged.
+func LoadStructFromFileInto[T any](filePath string, v *T) error {
+	decFunc := decoderFuncFromFilePath(filePath)
+
+	if decFunc == nil {
+		return fmt.Errorf("unrecognised file type. expected yaml/yml, json or jsonl/ndjson")
+	}
+
+	structFile, err := CleanOpen(filePath)
+	if err != nil {
+		return err
+	}
+
+	err = decFunc(structFile).Decode(v)
+	if errors.Is(err, io.EOF) {
+		err = nil
+	}
+
+	if err != nil {
+		closeErr := structFile.Close()
+		if closeErr != nil {
+			return fmt.Errorf("%w: %v", err, closeErr)
+		}
+		return err
+	}
+
+	return structFile.Close()
+}
+
+// LoadStructFromFileLimit loads a struct from a yaml/yml or json file, reading at most maxBytes from the file.
+// It returns ErrFileExceedsLimit if the file is larger than maxBytes.
+func LoadStructFromFileLimit[T any](filePath string, maxBytes int64) (*T, error) {
+	if maxBytes <= 0 {
+		return nil, fmt.Errorf("invalid limit %v. expected a positive number of bytes", maxBytes)
+	}
+	return loadStructFromFile[T](filePath, maxBytes)
+}
+
+// loadStructFromReaderLimit loads a struct from r with decFunc, reading at most maxBytes when maxBytes is positive
+func loadStructFromReaderLimit[T any](r io.Reader, decFunc decoderFunc, maxBytes int64) (*T, error) {
+	var limitedReader *sizeLimitReader
+	if maxBytes > 0 {
+		limitedReader = &sizeLimitReader{r: r, remaining: maxBytes}
+		r = limitedReader
+	}
+
+	data, err := loadStructFromReaderWithDecoder[T](r, decFunc)
+	if limitedReader != nil && limitedReader.exceeded {
+		return nil, fmt.Errorf("%w: %v bytes", ErrFileExceedsLimit, maxBytes)
+	}
+	return data, err
+}
+
+// sniffDecoderFunc returns the json decoder if the first non-space byte r will return is '{' or '[', and the
+// yaml decoder otherwise, without consuming anything from r.
+func sniffDecoderFunc(r *bufio.Reader) decoderFunc {
+	for n := 1; n <= r.Size(); n++ {
+		peeked, _ := r.Peek(n)
+		if len(peeked) < n {
+			break
+		}
+		switch peeked[n-1] {
+		case ' ', '\t', '\r', '\n':
+			continue
+		case '{', '[':
+			return jsonDecoderFunc
+		}
+		break
+	}
+	return yamlDecoderFunc
+}
+
+func loadStructFromFile[T any](filePath string, maxBytes int64) (*T, error) {
+	if filePath == "-" {
+		stdin := bufio.NewReader(os.Stdin)
+		return loadStructFromReaderLimit[T](stdin, sniffDecoderFunc(stdin), maxBytes)
+	}
+
+	decFunc := decoderFuncFromFilePath(filePath)
+
+	if decFunc == nil {
+		return nil, fmt.Errorf("unrecognised file type. expected yaml/yml, json or jsonl/ndjson")
+	}
+
+	return loadStructFromFileWithDecoder[T](filePath, decFunc, maxBytes)
+}
+
+func loadStructFromFileWithDecoder[T any](filePath string, decFunc decoderFunc, maxBytes int64) (*T, error) {
+	structFile, err := CleanOpen(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := loadStructFromReaderLimit[T](structFile, decFunc, maxBytes)
+
+	if err != nil {
+		closeErr := structFile.Close()
+		if closeErr != nil {
+			return nil, fmt.Errorf("%w: %v", err, closeErr)
+		}
+		return nil, err
+	}
+
+	return data, structFile.Close()
+}
+
+func isDecodeTypeError(err error) bool {
+	var yamlTypeError *yaml.TypeError
+	var jsonTypeError *json.UnmarshalTypeError
+	return errors.As(err, &yamlTypeError) || errors.As(err, &jsonTypeError)
+}
+
+// isJSONFormat reports whether format is json or JSON Lines
+func isJSONFormat(format string) bool {
+	return strings.EqualFold(format, "json") || isJSONLinesFormat(format)
+}
+
+func tagNameFromFilePath(path string) string {
+	if isJSONFormat(formatFromFilePath(path)) {
+		return "json"
+	}
+	return "yaml"
+}
+
+// LoadStructFromFileLenient loads a struct from a yaml/yml or json file like LoadStructFromFile but, if decoding
+// fails with a type mismatch, retries by decoding into an intermediate map and coercing values into the target
+// type, e.g. a quoted "8080" into an int field.
+func LoadStructFromFileLenient[T any](filePath string) (*T, error) {
+	data, err := LoadStructFromFile[T](filePath)
+	if err == nil || !isDecodeTypeError(err) {
+		return data, err
+	}
+
+	raw, err := LoadStructFromFile[any](filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var coerced T
+	err = coerceValue(*raw, reflect.ValueOf(&coerced).Elem(), tagNameFromFilePath(filePath))
+	if err != nil {
+		return nil, fmt.Errorf("failed to coerce data from file: %w", err)
+	}
+
+	if generics.IsZeroValue(coerced) {
+		return nil, fmt.Errorf("failed to load data from file")
+	}
+
+	return &coerced, nil
+}
+
+// zonelessTimestampLayouts are the yaml timestamp layouts that carry no zone information
+var zonelessTimestampLayouts = []string{"2006-1-2 15:4:5.999999999", "2006-1-2"}
+
+// markZonelessTimestamps rewrites the yaml timestamp values in node that have no zone information to the same
+// wall clock time with an explicit "+00:00" offset, so they decode with a location other than time.UTC, unlike
+// those given as UTC. Mapping keys are left unchanged.
+func markZonelessTimestamps(node *yaml.Node) {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		if node.ShortTag() != "!!timestamp" {
+			return
+		}
+		for _, layout := range zonelessTimestampLayouts {
+			if t, err := time.Parse(layout, node.Value); err == nil {
+				node.Value = t.Format("2006-01-02T15:04:05.999999999") + "+00:00"
+				return
+			}
+		}
+	case yaml.MappingNode:
+		for i := 1; i < len(node.Content); i += 2 {
+			markZonelessTimestamps(node.Content[i])
+		}
+	default:
+		for _, child := range node.Content {
+			markZonelessTimestamps(child)
+		}
+	}
+}
+
+// timesInLocation reinterprets, in place, each UTC time.Time reachable from v as the same wall clock time in loc
+// where the corresponding time in marked, decoded from the same yaml after markZonelessTimestamps, isn't UTC.
+// Nested structs, pointers, slices, arrays, maps and interfaces are walked, unexported fields are skipped.
+func timesInLocation(v, marked reflect.Value, loc *time.Location) {
+	if v.Type() == timeType {
+		t := v.Interface().(time.Time)
+		if t.Location() == time.UTC && marked.Interface().(time.Time).Location() != time.UTC {
+			v.Set(reflect.ValueOf(time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc)))
+		}
+		return
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if !v.IsNil() && !marked.IsNil() {
+			timesInLocation(v.Elem(), marked.Elem(), loc)
+		}
+	case reflect.Interface:
+		if !v.IsNil() && !marked.IsNil() && v.Elem().Type() == marked.Elem().Type() {
+			elem := reflect.New(v.Elem().Type()).Elem()
+			elem.Set(v.Elem())
+			timesInLocation(elem, marked.Elem(), loc)
+			v.Set(elem)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Field(i).CanSet() {
+				timesInLocation(v.Field(i), marked.Field(i), loc)
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len() && i < marked.Len(); i++ {
+			timesInLocation(v.Index(i), marked.Index(i), loc)
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			markedElem := marked.MapIndex(iter.Key())
+			if !markedElem.IsValid() {
+				continue
+			}
+			elem := reflect.New(v.Type().Elem()).Elem()
+			elem.Set(iter.Value())
+			timesInLocation(elem, markedElem, loc)
+			v.SetMapIndex(iter.Key(), elem)
+		}
+	}
+}
+
+// yamlInLocationDecoder decodes yaml documents like yaml.Decoder, reinterpreting timestamps without zone
+// information in loc
+type yamlInLocationDecoder struct {
+	decoder *yaml.Decoder
+	loc     *time.Location
+}
+
+func yamlInLocationDecoderFunc(loc *time.Location) decoderFunc {
+	return func(r io.Reader) decoder {
+		return &yamlInLocationDecoder{decoder: yaml.NewDecoder(r), loc: loc}
+	}
+}
+
+// Decode decodes the next document into v, then decodes it again with zone-less timestamps marked, so that only
+// the times that came from those are moved into loc
+func (d *yamlInLocationDecoder) Decode(v interface{}) error {
+	var node yaml.Node
+	if err := d.decoder.Decode(&node); err != nil {
+		return err
+	}
+	if err := node.Decode(v); err != nil {
+		return err
+	}
+
+	markZonelessTimestamps(&node)
+	marked := reflect.New(reflect.TypeOf(v).Elem())
+	if err := node.Decode(marked.Interface()); err != nil {
+		return err
+	}
+
+	timesInLocation(reflect.ValueOf(v).Elem(), marked.Elem(), d.loc)
+	return nil
+}
+
+// LoadStructFromFileInLocation loads a struct like LoadStructFromFile, interpreting yaml/yml timestamps without
+// zone information in loc rather than UTC. Timestamps with a zone, including "Z", and json files are unchanged,
+// as is stdin given as "-".
+func LoadStructFromFileInLocation[T any](filePath string, loc *time.Location) (*T, error) {
+	if loc == nil {
+		return nil, fmt.Errorf("location must not be nil")
+	}
+
+	if filePath == "-" || isJSONFormat(formatFromFilePath(filePath)) {
+		return LoadStructFromFile[T](filePath)
+	}
+
+	if decoderFuncFromFilePath(filePath) == nil {
+		return nil, fmt.Errorf("unrecognised file type. expected yaml/yml, json or jsonl/ndjson")
+	}
+
+	return loadStructFromFileWithDecoder[T](filePath, yamlInLocationDecoderFunc(loc), 0)
+}
+
+// yamlNodeAtKey returns the node at the dotted key within a yaml document
+func yamlNodeAtKey(node *yaml.Node, key string) (*yaml.Node, error) {
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+
+	for _, segment := range strings.Split(key, ".") {
+		if node.Kind == yaml.AliasNode {
+			node = node.Alias
+		}
+		if node.Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("key %v not found", key)
+		}
+
+		var next *yaml.Node
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == segment {
+				next = node.Content[i+1]
+			}
+		}
+		if next == nil {
+			return nil, fmt.Errorf("key %v not found", key)
+		}
+		node = next
+	}
+	return node, nil
+}
+
+// jsonAtKey returns the raw json at the dotted key within a json document
+func jsonAtKey(data json.RawMessage, key string) (json.RawMessage, error) {
+	for _, segment := range strings.Split(key, ".") {
+		var object map[string]json.RawMessage
+		if err := json.Unmarshal(data, &object); err != nil {
+			return nil, fmt.Errorf("key %v not found: %w", key, err)
+		}
+		next, ok := object[segment]
+		if !ok {
+			return nil, fmt.Errorf("key %v not found", key)
+		}
+		data = next
+	}
+	return data, nil
+}
+
+func loadStructFromReaderAtKey[T any](r io.Reader, format, key string) (*T, error) {
+	var data T
+
+	switch format {
+	case "json":
+		var document json.RawMessage
+		if err := json.NewDecoder(r).Decode(&document); err != nil {
+			return nil, err
+		}
+		subtree, err := jsonAtKey(document, key)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(subtree, &data); err != nil {
+			return nil, err
+		}
+	default:
+		var document yaml.Node
+		if err := yaml.NewDecoder(r).Decode(&document); err != nil {
+			return nil, err
+		}
+		subtree, err := yamlNodeAtKey(&document, key)
+		if err != nil {
+			return nil, err
+		}
+		if err := subtree.Decode(&data); err != nil {
+			return nil, err
+		}
+	}
+
+	if IsZero(data) {
+		return nil, fmt.Errorf("failed to load data from file")
+	}
+
+	return &data, nil
+}
+
+// LoadStructFromFileAtKey loads a struct from the value at a dotted key, e.g. "services.myapp", within a yaml/yml
+// or json file, for settings nested within a shared file.
+func LoadStructFromFileAtKey[T any](filePath string, key string) (*T, error) {
+	// JSON Lines files hold a record per line rather than a single document with keys
+	if format := formatFromFilePath(filePath); decoderFuncFromFormat(format) == nil || isJSONLinesFormat(format) {
+		return nil, fmt.Errorf("unrecognised file type. expected yaml/yml or json")
+	}
+
+	structFile, err := CleanOpen(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := loadStructFromReaderAtKey[T](structFile, formatFromFilePath(filePath), key)
+
 	if err != nil {
-		return "", err
+		closeErr := structFile.Close()
+		if closeErr != nil {
+			return nil, fmt.Errorf("%w: %v", err, closeErr)
+		}
+		return nil, err
 	}
 
-	err = os.MkdirAll(path, 0750)
-	if err != nil {
-		return "", err
+	return data, structFile.Close()
+}
+
+// anyFormatExtensions are tried in order by LoadStructFromFileAnyFormat
+var anyFormatExtensions = []string{".json", ".yaml", ".yml"}
+
+// LoadStructFromFileAnyFormat loads a struct from the first of basePath.json, basePath.yaml and basePath.yml
+// that exists. basePath should not include an extension.
+func LoadStructFromFileAnyFormat[T any](basePath string) (*T, error) {
+	candidates := generics.SafeMap(func(ext string) string {
+		return basePath + ext
+	}, anyFormatExtensions)
+
+	path, ok := FirstExistingFile(candidates...)
+	if !ok {
+		return nil, fmt.Errorf("no config file found. tried %v", strings.Join(candidates, ", "))
 	}
 
-	return path, nil
+	return LoadStructFromFile[T](path)
 }
 
-// ExpandPath expands a path to an absolute path.
-// It also expands ~ and environment variables.
-func ExpandPath(path string) (string, error) {
-	path, err := homedir.Expand(path)
+// LoadStructsFromDirMap loads each file with one of SupportedFormats directly under dir, keyed by the file name
+// without its extension, e.g. "tenant-a.yaml" is keyed "tenant-a". Subdirectories and other files are ignored.
+// Two files with the same name but different extensions are an error.
+func LoadStructsFromDirMap[T any](dir string) (map[string]*T, error) {
+	expandedDir, err := ExpandPath(dir)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	path = os.ExpandEnv(path)
+	entries, err := os.ReadDir(expandedDir)
+	if err != nil {
+		return nil, err
+	}
 
-	path = filepath.Clean(path)
+	items := make(map[string]*T)
+	sources := make(map[string]string)
+	for _, entry := range entries {
+		if entry.IsDir() || !IsSupportedConfigFile(entry.Name()) {
+			continue
+		}
 
-	path, err = filepath.Abs(path)
+		key := strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+		if source, ok := sources[key]; ok {
+			return nil, fmt.Errorf("duplicate key %v from %v and %v", key, source, entry.Name())
+		}
+
+		item, err := LoadStructFromFile[T](filepath.Join(expandedDir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to load %v: %w", entry.Name(), err)
+		}
+
+		items[key] = item
+		sources[key] = entry.Name()
+	}
+	return items, nil
+}
+
+// CheckFilePermissions returns an error if the (expanded) file has any permission bits set beyond maxPerm,
+// e.g. a secret file that is group or world readable when maxPerm is 0600.
+func CheckFilePermissions(path string, maxPerm os.FileMode) error {
+	expandedPath, err := ExpandPath(path)
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	return path, nil
+	info, err := os.Stat(expandedPath)
+	if err != nil {
+		return err
+	}
+
+	perm := info.Mode().Perm()
+	if excess := perm &^ maxPerm.Perm(); excess != 0 {
+		return fmt.Errorf("permissions %v for %v are too open. expected at most %v", perm, expandedPath, maxPerm.Perm())
+	}
+	return nil
 }
 
-// WaitForFiles waits for a set of files to exist, it will check every interval seconds up until max seconds.
-func WaitForFiles(interval, max uint, files ...string) error {
-	i := time.Duration(interval) * time.Second
-	return WaitFor(i, max, func() bool {
-		return FilesExist(files...)
-	})
+// LoadStructFromFileSecure loads a struct from a yaml/yml or json file like LoadStructFromFile,
+// refusing to load it if the file permissions are more open than 0600.
+func LoadStructFromFileSecure[T any](filePath string) (*T, error) {
+	err := CheckFilePermissions(filePath, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return LoadStructFromFile[T](filePath)
 }
 
-func fileExists(filename string) error {
-	_, err := os.Stat(filename)
-	return err
+// ReadFileLinesOptions controls which lines ReadFileLinesWithOptions returns, the zero value gives the defaults
+// used by ReadFileLines
+type ReadFileLinesOptions struct {
+	// KeepBlank returns blank lines, as empty strings, rather than skipping them
+	KeepBlank bool
+	// KeepComments returns lines starting with # rather than skipping them
+	KeepComments bool
 }
 
-// FilesExist checks if all file names exist.
-func FilesExist(files ...string) bool {
-	return generics.Apply(fileExists, files) == nil
+// ReadFileLines reads a file as a list of lines with surrounding whitespace trimmed, skipping blank lines and
+// lines starting with #, e.g. for allowlists and host lists.
+func ReadFileLines(path string) ([]string, error) {
+	return ReadFileLinesWithOptions(path, ReadFileLinesOptions{})
 }
 
-type decoder interface {
-	Decode(v interface{}) error
+// ReadFileLinesWithOptions reads a file as a list of lines with surrounding whitespace trimmed, skipping blank
+// lines and lines starting with # unless opts keeps them.
+func ReadFileLinesWithOptions(path string, opts ReadFileLinesOptions) ([]string, error) {
+	f, err := CleanOpen(path)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := make([]string, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" && !opts.KeepBlank {
+			continue
+		}
+		if strings.HasPrefix(line, "#") && !opts.KeepComments {
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		closeErr := f.Close()
+		if closeErr != nil {
+			return nil, fmt.Errorf("%w: %v", err, closeErr)
+		}
+		return nil, err
+	}
+
+	return lines, f.Close()
 }
 
-type encoder interface {
-	Encode(v interface{}) error
+// lineAndColumn returns the 1-based line and column of offset within data
+func lineAndColumn(data []byte, offset int64) (int, int) {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	before := data[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := int(offset) - bytes.LastIndexByte(before, '\n')
+	return line, column
 }
 
-type decoderFunc func(r io.Reader) decoder
-type encoderFunc func(w io.Writer) encoder
+func validateJSON(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
 
-func yamlDecoderFunc(r io.Reader) decoder {
-	return yaml.NewDecoder(r)
+	var v any
+	err := decoder.Decode(&v)
+	if err == nil {
+		if _, trailingErr := decoder.Token(); !errors.Is(trailingErr, io.EOF) {
+			line, column := lineAndColumn(data, decoder.InputOffset())
+			return fmt.Errorf("invalid json at line %d, column %d: unexpected data after top-level value", line, column)
+		}
+		return nil
+	}
+
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		line, column := lineAndColumn(data, syntaxErr.Offset)
+		return fmt.Errorf("invalid json at line %d, column %d: %w", line, column, err)
+	}
+	return fmt.Errorf("invalid json: %w", err)
 }
 
-func yamlEncoderFunc(w io.Writer) encoder {
-	return yaml.NewEncoder(w)
+func validateJSONLines(data []byte) error {
+	for i, line := range bytes.Split(data, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var v any
+		err := json.Unmarshal(line, &v)
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			return fmt.Errorf("invalid json at line %d, column %d: %w", i+1, syntaxErr.Offset, err)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid json at line %d: %w", i+1, err)
+		}
+	}
+	return nil
 }
 
-func jsonDecoderFunc(r io.Reader) decoder {
-	return json.NewDecoder(r)
+func validateYAML(data []byte) error {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var node yaml.Node
+		err := decoder.Decode(&node)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid yaml: %w", err)
+		}
+	}
 }
 
-func jsonEncoderFunc(w io.Writer) encoder {
-	return json.NewEncoder(w)
+// ValidateConfigFile checks that a yaml/yml, json or jsonl/ndjson file is syntactically valid, without decoding it
+// into a type.
+// Errors include the line where the problem was found when the decoder provides it.
+func ValidateConfigFile(path string) error {
+	validate := map[string]func([]byte) error{
+		"json":   validateJSON,
+		"jsonl":  validateJSONLines,
+		"ndjson": validateJSONLines,
+		"yaml":   validateYAML,
+		"yml":    validateYAML,
+	}[formatFromFilePath(path)]
+	if validate == nil {
+		return fmt.Errorf("unrecognised file type. expected yaml/yml, json or jsonl/ndjson")
+	}
+
+	f, err := CleanOpen(path)
+	if err != nil {
+		return err
+	}
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		closeErr := f.Close()
+		if closeErr != nil {
+			return fmt.Errorf("%w: %v", err, closeErr)
+		}
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return validate(data)
 }
 
-func encoderFuncFromFilePath(path string) encoderFunc {
-	switch {
-	case strings.HasSuffix(path, ".yaml"), strings.HasSuffix(path, ".yml"):
-		return yamlEncoderFunc
-	case strings.HasSuffix(path, ".json"):
-		return jsonEncoderFunc
-	default:
-		return nil
+// SaveStructToFile saves a struct to a yaml/yml or json file, the format is inferred from the file extension.
+// Output is byte-stable for the same input: both encoders write map keys, including those of nested maps,
+// in sorted order. Types with custom MarshalJSON/MarshalYAML methods are responsible for their own ordering.
+func SaveStructToFile[T any](v *T, filePath string) error {
+	encFunc := encoderFuncFromFilePath(filePath)
+
+	if encFunc == nil {
+		return fmt.Errorf("unrecognised file type. expected yaml/yml, json or jsonl/ndjson")
+	}
+
+	structFile, err := CleanCreate(filePath)
+	if err != nil {
+		return err
+	}
+
+	err = saveStructToWriterWithEncoder[T](v, structFile, encFunc)
+
+	if err != nil {
+		closeErr := structFile.Close()
+		if closeErr != nil {
+			return fmt.Errorf("%w: %v", err, closeErr)
+		}
+		return err
 	}
+
+	return structFile.Close()
 }
 
-func decoderFuncFromFilePath(path string) decoderFunc {
-	switch {
-	case strings.HasSuffix(path, ".yaml"), strings.HasSuffix(path, ".yml"):
-		return yamlDecoderFunc
-	case strings.HasSuffix(path, ".json"):
-		return jsonDecoderFunc
+// pruneEmpty returns a copy of v with zero values, empty strings and empty maps and slices removed from maps,
+// recursing through maps, slices and interfaces. It reports whether v itself is empty. Struct fields are not
+// pruned, a struct is only treated as empty when it is the zero value.
+func pruneEmpty(v reflect.Value) (reflect.Value, bool) {
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.IsNil() {
+			return v, true
+		}
+		return pruneEmpty(v.Elem())
+	case reflect.Map:
+		if v.Len() == 0 {
+			return v, true
+		}
+		pruned := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			value, empty := pruneEmpty(iter.Value())
+			if !empty {
+				pruned.SetMapIndex(iter.Key(), value)
+			}
+		}
+		return pruned, pruned.Len() == 0
+	case reflect.Slice:
+		if v.Len() == 0 {
+			return v, true
+		}
+		pruned := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			value, _ := pruneEmpty(v.Index(i))
+			if !value.IsValid() {
+				value = v.Index(i)
+			}
+			pruned.Index(i).Set(value)
+		}
+		return pruned, false
+	case reflect.Invalid:
+		return v, true
 	default:
-		return nil
+		return v, v.IsZero()
 	}
 }
 
-func saveStructToWriterWithEncoder[T any](v *T, w io.Writer, eFunc encoderFunc) error {
-	encoder := eFunc(w)
-	return encoder.Encode(v)
-}
+// SaveStructToFileOmitEmpty saves v like SaveStructToFile, first removing zero values, empty strings and empty
+// maps and slices from any maps, including nested maps and maps within slices. Slice elements are kept, even
+// when pruned to empty, so positions are preserved. This is intended for map/any-shaped data: fields of struct types are not pruned
+// and still require `json:",omitempty"`/`yaml:",omitempty"` tags to be omitted.
+func SaveStructToFileOmitEmpty[T any](v *T, filePath string) error {
+	if v == nil {
+		return SaveStructToFile(v, filePath)
+	}
 
-func loadStructFromReaderWithDecoder[T any](r io.Reader, dFunc decoderFunc) (*T, error) {
-	var data T
+	value := reflect.ValueOf(v).Elem()
+	if value.Kind() != reflect.Map && value.Kind() != reflect.Interface && value.Kind() != reflect.Slice {
+		return SaveStructToFile(v, filePath)
+	}
 
-	encoder := dFunc(r)
-	err := encoder.Decode(&data)
-	if err != nil {
-		return nil, err
+	pruned := reflect.New(value.Type())
+	prunedValue, empty := pruneEmpty(value)
+	switch {
+	case !empty:
+		pruned.Elem().Set(prunedValue)
+	case value.Kind() == reflect.Map:
+		pruned.Elem().Set(reflect.MakeMap(value.Type()))
 	}
 
-	if generics.IsZeroValue(data) {
-		return nil, fmt.Errorf("failed to load data from file")
+	return SaveStructToFile(pruned.Interface().(*T), filePath)
+}
+
+// ConvertStructFile loads a T from srcPath and saves it to dstPath, converting between yaml/yml and json
+// based on the file extensions.
+func ConvertStructFile[T any](srcPath, dstPath string) error {
+	v, err := LoadStructFromFile[T](srcPath)
+	if err != nil {
+		return err
 	}
+	return SaveStructToFile(v, dstPath)
+}
 
-	return &data, nil
+// ConvertFile converts srcPath to dstPath, between yaml/yml and json based on the file extensions,
+// without needing a type by decoding into a map[string]any.
+func ConvertFile(srcPath, dstPath string) error {
+	return ConvertStructFile[map[string]any](srcPath, dstPath)
 }
 
-func LoadStructFromFile[T any](filePath string) (*T, error) {
-	decFunc := decoderFuncFromFilePath(filePath)
+// structFileLock is a per-path mutex, counting the holders and waiters so it can be removed once unused
+type structFileLock struct {
+	mu   sync.Mutex
+	refs int
+}
 
-	if decFunc == nil {
-		return nil, fmt.Errorf("unrecognised file type. expected yaml/yml or json")
+// structFileLocks serialises read-modify-write updates to the same file within the process, only holding
+// entries for paths that are locked or waited on
+var (
+	structFileLocksMu sync.Mutex
+	structFileLocks   = make(map[string]*structFileLock)
+)
+
+func lockStructFile(path string) func() {
+	structFileLocksMu.Lock()
+	l, ok := structFileLocks[path]
+	if !ok {
+		l = &structFileLock{}
+		structFileLocks[path] = l
 	}
+	l.refs++
+	structFileLocksMu.Unlock()
 
-	structFile, err := CleanOpen(filePath)
+	l.mu.Lock()
+	return func() {
+		l.mu.Unlock()
+
+		structFileLocksMu.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(structFileLocks, path)
+		}
+		structFileLocksMu.Unlock()
+	}
+}
+
+// lockStructFileSidecar takes an exclusive advisory lock on a ".<name>.lock" file next to path, serialising
+// updates across processes. The file being updated can't be locked itself as it is replaced on each save.
+// The lock file is left in place, removing it would let another process lock a new file while the old one is
+// still held.
+func lockStructFileSidecar(path string) (func() error, error) {
+	lockPath := filepath.Join(filepath.Dir(path), fmt.Sprintf(".%s.lock", filepath.Base(path)))
+	f, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0600)
 	if err != nil {
 		return nil, err
 	}
 
-	data, err := loadStructFromReaderWithDecoder[T](structFile, decFunc)
-
+	unlockFile, err := lockFile(f)
 	if err != nil {
-		closeErr := structFile.Close()
+		closeErr := f.Close()
 		if closeErr != nil {
 			return nil, fmt.Errorf("%w: %v", err, closeErr)
 		}
 		return nil, err
 	}
 
-	return data, structFile.Close()
+	return func() error {
+		err := unlockFile()
+		closeErr := f.Close()
+		if err != nil {
+			return err
+		}
+		return closeErr
+	}, nil
 }
 
-func SaveStructToFile[T any](v *T, filePath string) error {
+// writeAtomic calls write with a temporary file in filePath's directory, syncs it, applies perm and renames it
+// over filePath, so readers see either the previous or the complete new content. The temporary file is removed
+// if any step fails.
+func writeAtomic(filePath string, perm os.FileMode, write func(io.Writer) (int64, error)) (int64, error) {
+	filePathDir, err := EnsureParentDir(filePath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create directory path: %w", err)
+	}
+
+	tmpFile, err := os.CreateTemp(filePathDir, fmt.Sprintf(".%s.tmp-*", filepath.Base(filePath)))
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := write(tmpFile)
+	if err == nil {
+		err = tmpFile.Chmod(perm)
+	}
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+
+	closeErr := tmpFile.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(tmpFile.Name(), filepath.Join(filePathDir, filepath.Base(filePath)))
+	}
+
+	if err != nil {
+		removeErr := os.Remove(tmpFile.Name())
+		if removeErr != nil {
+			return n, fmt.Errorf("%w: %v", err, removeErr)
+		}
+		return n, err
+	}
+
+	return n, nil
+}
+
+// saveStructToFileAtomic saves a struct to a temporary file in the same directory as filePath
+// and renames it over filePath, so readers never observe a partially written file.
+func saveStructToFileAtomic[T any](v *T, filePath string) error {
 	encFunc := encoderFuncFromFilePath(filePath)
 
 	if encFunc == nil {
-		return fmt.Errorf("unrecognised file type. expected yaml/yml or json")
+		return fmt.Errorf("unrecognised file type. expected yaml/yml, json or jsonl/ndjson")
 	}
 
-	filePathDir := filepath.Dir(filePath)
-	_, err := CreateDirPath(filePathDir, "")
+	_, err := writeAtomic(filePath, 0600, func(w io.Writer) (int64, error) {
+		return 0, saveStructToWriterWithEncoder[T](v, w, encFunc)
+	})
+	return err
+}
+
+// WriteReaderAtomic atomically replaces the file at path with the content of r, creating it with perm and its
+// parent directory if they don't exist. The content is written to a temporary file in the same directory,
+// synced and renamed over path, so readers never see partial content. It returns the number of bytes written.
+func WriteReaderAtomic(path string, r io.Reader, perm os.FileMode) (int64, error) {
+	return writeAtomic(path, perm, func(w io.Writer) (int64, error) {
+		return io.Copy(w, r)
+	})
+}
+
+// RenderFileTemplateToFile expands the (expanded) template file with data, as ExpandStringTemplate does, and
+// atomically writes the result to outPath with 0600 permissions, as WriteReaderAtomic does. outPath is left
+// unchanged if the template can't be read or expanded.
+func RenderFileTemplateToFile(templatePath, outPath string, data any) error {
+	templateFile, err := CleanOpen(templatePath)
 	if err != nil {
-		return fmt.Errorf("failed to create directory path: %w", err)
+		return err
 	}
 
-	structFile, err := CleanOpenFile(filePath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	content, err := io.ReadAll(templateFile)
 	if err != nil {
+		closeErr := templateFile.Close()
+		if closeErr != nil {
+			return fmt.Errorf("%w: %v", err, closeErr)
+		}
 		return err
 	}
 
-	err = saveStructToWriterWithEncoder[T](v, structFile, encFunc)
+	err = templateFile.Close()
+	if err != nil {
+		return err
+	}
 
+	rendered, err := ExpandStringTemplate(string(content), data)
 	if err != nil {
-		closeErr := structFile.Close()
-		if closeErr != nil {
-			return fmt.Errorf("%w: %v", err, closeErr)
+		return fmt.Errorf("failed to expand template %v: %w", templatePath, err)
+	}
+
+	_, err = WriteReaderAtomic(outPath, strings.NewReader(rendered), 0600)
+	return err
+}
+
+// UpdateStructFile loads a struct from a yaml/yml or json file, applies mutate to it and atomically saves it back.
+// If mutate returns an error the file is left unchanged and the error is returned.
+// Concurrent updates to the same file are serialised, across processes via an advisory lock on a ".<name>.lock"
+// file next to it where supported.
+func UpdateStructFile[T any](filePath string, mutate func(*T) error) (err error) {
+	expandedPath, err := ExpandPath(filePath)
+	if err != nil {
+		return err
+	}
+
+	unlock := lockStructFile(expandedPath)
+	defer unlock()
+
+	unlockSidecar, err := lockStructFileSidecar(expandedPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if unlockErr := unlockSidecar(); err == nil {
+			err = unlockErr
 		}
+	}()
+
+	data, err := LoadStructFromFile[T](expandedPath)
+	if err != nil {
 		return err
 	}
 
-	return structFile.Close()
+	err = mutate(data)
+	if err != nil {
+		return err
+	}
+
+	return saveStructToFileAtomic(data, expandedPath)
+}
+
+// FilterStructs returns the loaded structs for which keep returns true, preserving order.
+func FilterStructs[T any](items []*T, keep func(*T) bool) []*T {
+	filtered := make([]*T, 0, len(items))
+	for _, item := range items {
+		if keep(item) {
+			filtered = append(filtered, item)
+		}
+	}
+	return filtered
+}
+
+// MapStructs applies f to each of the loaded structs, returning the results in order.
+func MapStructs[T any, U any](items []*T, f func(*T) U) []U {
+	return generics.SafeMap(f, items)
 }
@@ -1,8 +1,24 @@
 package util
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
 	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/mitchellh/go-homedir"
 )
 
 func TestExpandPath(t *testing.T) {
@@ -23,3 +39,2123 @@ func TestExpandPath(t *testing.T) {
 		t.Errorf("expected '/home/test' got '%s'", path)
 	}
 }
+
+func TestEnsureParentDir(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	filePath := filepath.Join(tmpDir, "a", "b", "c", "file.yaml")
+	dir, err := EnsureParentDir(filePath)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expectedDir := filepath.Join(tmpDir, "a", "b", "c")
+	if dir != expectedDir {
+		t.Errorf("expected '%s' got '%s'", expectedDir, dir)
+	}
+
+	for _, d := range []string{"a", "a/b", "a/b/c"} {
+		info, err := os.Stat(filepath.Join(tmpDir, d))
+		if err != nil {
+			t.Fatalf("expected %s to exist: %s", d, err)
+		}
+		if !info.IsDir() {
+			t.Errorf("expected %s to be a directory", d)
+		}
+	}
+
+	if _, err := os.Stat(filePath); !os.IsNotExist(err) {
+		t.Errorf("expected file itself not to be created")
+	}
+}
+
+type testConfig struct {
+	Name  string `json:"name" yaml:"name"`
+	Count int    `json:"count" yaml:"count"`
+}
+
+func TestLoadStructFromReaderWithFormat(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		input  string
+	}{
+		{
+			name:   "json",
+			format: "json",
+			input:  `{"name": "test", "count": 3}`,
+		},
+		{
+			name:   "yaml",
+			format: "yaml",
+			input:  "name: test\ncount: 3\n",
+		},
+		{
+			name:   "yml",
+			format: "YML",
+			input:  "name: test\ncount: 3\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadStructFromReaderWithFormat[testConfig](strings.NewReader(tt.input), tt.format)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if cfg.Name != "test" || cfg.Count != 3 {
+				t.Errorf("expected {test 3} got %v", *cfg)
+			}
+		})
+	}
+}
+
+func TestLoadStructFromReaderWithFormatUnknown(t *testing.T) {
+	_, err := loadStructFromReaderWithFormat[testConfig](strings.NewReader(`{}`), "toml")
+	if err == nil {
+		t.Errorf("expected error for unknown format")
+	}
+}
+
+// setTestStdin replaces os.Stdin with a file holding content for the duration of the test
+func setTestStdin(t *testing.T, content string) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "stdin")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	stdin := os.Stdin
+	os.Stdin = f
+	t.Cleanup(func() {
+		os.Stdin = stdin
+		_ = f.Close()
+	})
+}
+
+func TestLoadStructFromFileStdinPath(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{name: "json", input: `{"name": "test", "count": 3}`},
+		{name: "yaml", input: "name: test\ncount: 3\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setTestStdin(t, tt.input)
+
+			cfg, err := LoadStructFromFile[testConfig]("-")
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if cfg.Name != "test" || cfg.Count != 3 {
+				t.Errorf("expected {test 3} got %v", *cfg)
+			}
+		})
+	}
+
+	t.Run("json tags", func(t *testing.T) {
+		type portConfig struct {
+			ServerPort int `json:"server_port" yaml:"serverPort"`
+		}
+
+		for _, input := range []string{" \n{\"server_port\": 8080}", "serverPort: 8080\n"} {
+			setTestStdin(t, input)
+
+			cfg, err := LoadStructFromFile[portConfig]("-")
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if cfg.ServerPort != 8080 {
+				t.Errorf("expected 8080 got %d for '%s'", cfg.ServerPort, input)
+			}
+		}
+	})
+
+	t.Run("limit", func(t *testing.T) {
+		setTestStdin(t, `{"name": "test", "count": 3}`)
+
+		if _, err := LoadStructFromFileLimit[testConfig]("-", 5); !errors.Is(err, ErrFileExceedsLimit) {
+			t.Errorf("expected ErrFileExceedsLimit got %v", err)
+		}
+	})
+}
+
+func TestLoadStructFromFileLimit(t *testing.T) {
+	content := []byte(`{"name": "test", "count": 3}`)
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, content, 0600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	size := int64(len(content))
+
+	tests := []struct {
+		name        string
+		maxBytes    int64
+		expectLimit bool
+	}{
+		{name: "well under limit", maxBytes: size * 10, expectLimit: false},
+		{name: "exactly at limit", maxBytes: size, expectLimit: false},
+		{name: "just over limit", maxBytes: size - 1, expectLimit: true},
+		{name: "well over limit", maxBytes: 4, expectLimit: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := LoadStructFromFileLimit[testConfig](path, tt.maxBytes)
+			if tt.expectLimit {
+				if !errors.Is(err, ErrFileExceedsLimit) {
+					t.Fatalf("expected ErrFileExceedsLimit got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if cfg.Name != "test" || cfg.Count != 3 {
+				t.Errorf("expected {test 3} got %v", *cfg)
+			}
+		})
+	}
+}
+
+func TestLoadStructFromFileLimitYAML(t *testing.T) {
+	content := []byte("name: test\ncount: 3\n")
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, content, 0600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if _, err := LoadStructFromFileLimit[testConfig](path, int64(len(content))); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if _, err := LoadStructFromFileLimit[testConfig](path, int64(len(content)-1)); !errors.Is(err, ErrFileExceedsLimit) {
+		t.Fatalf("expected ErrFileExceedsLimit got %v", err)
+	}
+}
+
+func TestUpdateStructFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	if err := SaveStructToFile(&testConfig{Name: "test", Count: 1}, path); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	err := UpdateStructFile(path, func(c *testConfig) error {
+		c.Count++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	cfg, err := LoadStructFromFile[testConfig](path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if cfg.Count != 2 {
+		t.Errorf("expected count 2 got %d", cfg.Count)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if !reflect.DeepEqual(names, []string{".config.yaml.lock", "config.yaml"}) {
+		t.Errorf("expected only the config and lock files to remain, got %v", names)
+	}
+}
+
+func TestUpdateStructFileMutateError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := SaveStructToFile(&testConfig{Name: "test", Count: 1}, path); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	before, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	mutateErr := errors.New("mutate failed")
+	err = UpdateStructFile(path, func(c *testConfig) error {
+		c.Count = 100
+		return mutateErr
+	})
+	if !errors.Is(err, mutateErr) {
+		t.Fatalf("expected mutate error got %v", err)
+	}
+
+	after, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(before) != string(after) {
+		t.Errorf("expected file to be unchanged, got '%s'", after)
+	}
+}
+
+func TestUpdateStructFileConcurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := SaveStructToFile(&testConfig{Name: "test", Count: 0}, path); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := UpdateStructFile(path, func(c *testConfig) error {
+				c.Count++
+				return nil
+			})
+			if err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	cfg, err := LoadStructFromFile[testConfig](path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if cfg.Count != 20 {
+		t.Errorf("expected count 20 got %d", cfg.Count)
+	}
+
+	structFileLocksMu.Lock()
+	defer structFileLocksMu.Unlock()
+	if len(structFileLocks) != 0 {
+		t.Errorf("expected unused locks to be removed, got %d", len(structFileLocks))
+	}
+}
+
+func TestCleanCreate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a", "b", "file.txt")
+
+	f, err := CleanCreate(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := f.WriteString("content"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("expected file to exist: %s", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expected mode 0600 got %v", info.Mode().Perm())
+	}
+
+	f, err = CleanCreate(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(content) != 0 {
+		t.Errorf("expected file to be truncated got '%s'", content)
+	}
+}
+
+func TestFirstExistingFile(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("TEST_CONFIG_DIR", dir)
+
+	second := filepath.Join(dir, "second.yaml")
+	third := filepath.Join(dir, "third.yaml")
+	for _, p := range []string{second, third} {
+		if err := os.WriteFile(p, []byte("name: test\n"), 0600); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	path, ok := FirstExistingFile(filepath.Join(dir, "first.yaml"), "$TEST_CONFIG_DIR/second.yaml", third)
+	if !ok {
+		t.Fatalf("expected a file to be found")
+	}
+	if path != second {
+		t.Errorf("expected '%s' got '%s'", second, path)
+	}
+
+	path, ok = FirstExistingFile(filepath.Join(dir, "missing.yaml"), filepath.Join(dir, "also-missing.yaml"))
+	if ok || path != "" {
+		t.Errorf("expected no file to be found got '%s'", path)
+	}
+}
+
+func TestWaitForFilesDetailed(t *testing.T) {
+	dir := t.TempDir()
+	present := filepath.Join(dir, "present")
+	missing := filepath.Join(dir, "missing")
+	if err := os.WriteFile(present, []byte{}, 0600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	notFound, err := WaitForFilesDetailed(context.Background(), time.Millisecond, 3, present, missing)
+	if err == nil {
+		t.Fatalf("expected error")
+	}
+	if !strings.Contains(err.Error(), missing) {
+		t.Errorf("expected error to name '%s' got '%s'", missing, err)
+	}
+	if len(notFound) != 1 || notFound[0] != missing {
+		t.Errorf("expected [%s] got %v", missing, notFound)
+	}
+
+	notFound, err = WaitForFilesDetailed(context.Background(), time.Millisecond, 3, present)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if notFound == nil || len(notFound) != 0 {
+		t.Errorf("expected empty slice got %v", notFound)
+	}
+}
+
+func TestWaitForFilesDetailedAppears(t *testing.T) {
+	dir := t.TempDir()
+	late := filepath.Join(dir, "late")
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		_ = os.WriteFile(late, []byte{}, 0600)
+	}()
+
+	notFound, err := WaitForFilesDetailed(context.Background(), 5*time.Millisecond, 1000, late)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(notFound) != 0 {
+		t.Errorf("expected empty slice got %v", notFound)
+	}
+}
+
+func TestWaitForFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	if err := WaitForFile(context.Background(), time.Millisecond, 2, path); err == nil {
+		t.Errorf("expected error for missing file")
+	}
+
+	if err := os.WriteFile(path, []byte{}, 0600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := WaitForFile(context.Background(), time.Millisecond, 2, path); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestWaitForFileSlowStatCanceled(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	slowStat := func(path string) (os.FileInfo, error) {
+		<-release
+		return os.Stat(path)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := waitForFile(ctx, realClock{}, slowStat, time.Millisecond, 5, filepath.Join(t.TempDir(), "file"))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected prompt return got %s", elapsed)
+	}
+}
+
+type stableConfig struct {
+	Name   string                       `json:"name" yaml:"name"`
+	Labels map[string]string            `json:"labels" yaml:"labels"`
+	Nested map[string]map[string]int    `json:"nested" yaml:"nested"`
+	Any    map[string]any               `json:"any" yaml:"any"`
+	Groups map[string][]map[string]bool `json:"groups" yaml:"groups"`
+}
+
+func newStableConfig(keys []string) *stableConfig {
+	cfg := &stableConfig{
+		Name:   "stable",
+		Labels: map[string]string{},
+		Nested: map[string]map[string]int{},
+		Any:    map[string]any{},
+		Groups: map[string][]map[string]bool{},
+	}
+	for _, k := range keys {
+		cfg.Labels[k] = k
+		cfg.Nested[k] = map[string]int{k + "-x": len(k), k + "-a": len(k)}
+		cfg.Any[k] = map[string]any{"z": k, "a": len(k)}
+		cfg.Groups[k] = []map[string]bool{{"z": true, "a": false}}
+	}
+	return cfg
+}
+
+func TestSaveStructToFileCyclic(t *testing.T) {
+	type node struct {
+		Name string
+		Next *node
+	}
+
+	n := &node{Name: "loop"}
+	n.Next = n
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	var unsupportedErr *json.UnsupportedValueError
+	if err := SaveStructToFile(n, path); !errors.As(err, &unsupportedErr) {
+		t.Errorf("expected unsupported value error got %v", err)
+	}
+}
+
+func TestSaveStructToFileStableOutput(t *testing.T) {
+	keys := []string{"zeta", "alpha", "mu", "beta", "omega", "delta", "kappa", "gamma"}
+	reversed := make([]string, len(keys))
+	for i, k := range keys {
+		reversed[len(keys)-1-i] = k
+	}
+
+	for _, ext := range []string{".yaml", ".json"} {
+		t.Run(ext, func(t *testing.T) {
+			dir := t.TempDir()
+
+			var expected []byte
+			for i := 0; i < 10; i++ {
+				order := keys
+				if i%2 == 1 {
+					order = reversed
+				}
+
+				path := filepath.Join(dir, fmt.Sprintf("config-%d%s", i, ext))
+				if err := SaveStructToFile(newStableConfig(order), path); err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+
+				content, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+
+				if expected == nil {
+					expected = content
+					continue
+				}
+				if string(content) != string(expected) {
+					t.Fatalf("expected stable output, run %d differs:\n%s\nvs\n%s", i, expected, content)
+				}
+			}
+
+			if strings.Index(string(expected), "alpha") > strings.Index(string(expected), "zeta") {
+				t.Errorf("expected map keys to be sorted:\n%s", expected)
+			}
+		})
+	}
+}
+
+func TestCheckFilePermissions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secret.yaml")
+	if err := os.WriteFile(path, []byte("name: test\n"), 0600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	tests := []struct {
+		name          string
+		mode          os.FileMode
+		maxPerm       os.FileMode
+		errorExpected bool
+	}{
+		{name: "owner read write", mode: 0600, maxPerm: 0600},
+		{name: "owner read only", mode: 0400, maxPerm: 0600},
+		{name: "world readable", mode: 0644, maxPerm: 0600, errorExpected: true},
+		{name: "group readable", mode: 0640, maxPerm: 0600, errorExpected: true},
+		{name: "group readable allowed", mode: 0640, maxPerm: 0640},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.Chmod(path, tt.mode); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			err := CheckFilePermissions(path, tt.maxPerm)
+			if tt.errorExpected && err == nil {
+				t.Errorf("expected error")
+			}
+			if !tt.errorExpected && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+func TestLoadStructFromFileSecure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secret.yaml")
+	if err := os.WriteFile(path, []byte("name: test\n"), 0600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	cfg, err := LoadStructFromFileSecure[testConfig](path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if cfg.Name != "test" {
+		t.Errorf("expected 'test' got '%s'", cfg.Name)
+	}
+
+	if err := os.Chmod(path, 0644); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := LoadStructFromFileSecure[testConfig](path); err == nil {
+		t.Errorf("expected error for world readable file")
+	}
+}
+
+func TestLoadStructFromFileAnyFormat(t *testing.T) {
+	tests := []struct {
+		name          string
+		files         map[string]string
+		expectedName  string
+		errorExpected bool
+	}{
+		{
+			name:         "only yaml",
+			files:        map[string]string{"config.yaml": "name: yaml\n"},
+			expectedName: "yaml",
+		},
+		{
+			name:         "only yml",
+			files:        map[string]string{"config.yml": "name: yml\n"},
+			expectedName: "yml",
+		},
+		{
+			name:         "only json",
+			files:        map[string]string{"config.json": `{"name": "json"}`},
+			expectedName: "json",
+		},
+		{
+			name: "json preferred",
+			files: map[string]string{
+				"config.json": `{"name": "json"}`,
+				"config.yaml": "name: yaml\n",
+			},
+			expectedName: "json",
+		},
+		{
+			name:          "neither",
+			files:         map[string]string{"other.yaml": "name: other\n"},
+			errorExpected: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tt.files {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+			}
+
+			cfg, err := LoadStructFromFileAnyFormat[testConfig](filepath.Join(dir, "config"))
+			if tt.errorExpected {
+				if err == nil {
+					t.Fatalf("expected error got %v", cfg)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if cfg.Name != tt.expectedName {
+				t.Errorf("expected '%s' got '%s'", tt.expectedName, cfg.Name)
+			}
+		})
+	}
+}
+
+func setTestHome(t *testing.T, home string) {
+	t.Helper()
+	t.Setenv("HOME", home)
+	homedir.Reset()
+	t.Cleanup(homedir.Reset)
+}
+
+func TestCleanWalk(t *testing.T) {
+	home := t.TempDir()
+	setTestHome(t, home)
+
+	files := []string{
+		"configs/app.yaml",
+		"configs/nested/db.json",
+		"configs/nested/deeper/cache.yml",
+	}
+	for _, f := range files {
+		path := filepath.Join(home, f)
+		if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if err := os.WriteFile(path, []byte{}, 0600); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	visited := map[string]bool{}
+	err := CleanWalk("~/configs", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(home, path)
+		if err != nil {
+			return err
+		}
+		visited[rel] = true
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := append([]string{"configs", "configs/nested", "configs/nested/deeper"}, files...)
+	for _, e := range expected {
+		if !visited[e] {
+			t.Errorf("expected %s to be visited", e)
+		}
+	}
+	if len(visited) != len(expected) {
+		t.Errorf("expected %d entries visited got %d: %v", len(expected), len(visited), visited)
+	}
+}
+
+func TestIsWritable(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, []byte("content"), 0600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	for _, path := range []string{dir, file} {
+		writable, err := IsWritable(path)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !writable {
+			t.Errorf("expected %s to be writable", path)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected temp file to be removed, got %d entries", len(entries))
+	}
+
+	content, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(content) != "content" {
+		t.Errorf("expected file to be unchanged got '%s'", content)
+	}
+
+	if _, err := IsWritable(filepath.Join(dir, "missing")); err == nil {
+		t.Errorf("expected error for missing path")
+	}
+}
+
+func TestIsWritableReadOnly(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("permission checks don't apply to root")
+	}
+
+	dir := filepath.Join(t.TempDir(), "readonly")
+	if err := os.Mkdir(dir, 0500); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	t.Cleanup(func() {
+		_ = os.Chmod(dir, 0700)
+	})
+
+	file := filepath.Join(t.TempDir(), "readonly-file")
+	if err := os.WriteFile(file, []byte{}, 0400); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	for _, path := range []string{dir, file} {
+		writable, err := IsWritable(path)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if writable {
+			t.Errorf("expected %s not to be writable", path)
+		}
+	}
+}
+
+func TestExpandStructPaths(t *testing.T) {
+	home := t.TempDir()
+	setTestHome(t, home)
+	t.Setenv("TEST_DATA_DIR", "/data")
+
+	type storage struct {
+		Dir  string `path:"true"`
+		Name string
+	}
+
+	type config struct {
+		ConfigFile string   `path:"true"`
+		LogFile    *string  `path:"true"`
+		Includes   []string `path:"true"`
+		Empty      string   `path:"true"`
+		Untagged   string
+		Storage    storage
+		Backups    []*storage
+		Optional   *storage
+	}
+
+	logFile := "$TEST_DATA_DIR/logs/app.log"
+	cfg := &config{
+		ConfigFile: "~/.app/config.yaml",
+		LogFile:    &logFile,
+		Includes:   []string{"~/a.yaml", "$TEST_DATA_DIR/b.yaml"},
+		Untagged:   "~/untouched",
+		Storage:    storage{Dir: "~/storage", Name: "~/name"},
+		Backups:    []*storage{{Dir: "$TEST_DATA_DIR/backup"}, nil},
+	}
+
+	if err := ExpandStructPaths(cfg); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expectations := map[string][2]string{
+		"ConfigFile":   {filepath.Join(home, ".app/config.yaml"), cfg.ConfigFile},
+		"LogFile":      {"/data/logs/app.log", *cfg.LogFile},
+		"Includes[0]":  {filepath.Join(home, "a.yaml"), cfg.Includes[0]},
+		"Includes[1]":  {"/data/b.yaml", cfg.Includes[1]},
+		"Empty":        {"", cfg.Empty},
+		"Untagged":     {"~/untouched", cfg.Untagged},
+		"Storage.Dir":  {filepath.Join(home, "storage"), cfg.Storage.Dir},
+		"Storage.Name": {"~/name", cfg.Storage.Name},
+		"Backups[0]":   {"/data/backup", cfg.Backups[0].Dir},
+	}
+	for name, e := range expectations {
+		if e[0] != e[1] {
+			t.Errorf("%s: expected '%s' got '%s'", name, e[0], e[1])
+		}
+	}
+}
+
+func TestExpandStructPathsInvalid(t *testing.T) {
+	type config struct {
+		Port int `path:"true"`
+	}
+
+	if err := ExpandStructPaths(&config{}); err == nil {
+		t.Errorf("expected error for non-string path field")
+	}
+	if err := ExpandStructPaths(config{}); err == nil {
+		t.Errorf("expected error for non-pointer")
+	}
+}
+
+func TestIsStale(t *testing.T) {
+	dir := t.TempDir()
+	output := filepath.Join(dir, "output")
+	input1 := filepath.Join(dir, "input1")
+	input2 := filepath.Join(dir, "input2")
+
+	for _, p := range []string{output, input1, input2} {
+		if err := os.WriteFile(p, []byte{}, 0600); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	base := time.Now().Add(-time.Hour)
+	setModTime := func(path string, offset time.Duration) {
+		mtime := base.Add(offset)
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	setModTime(input1, 0)
+	setModTime(input2, time.Minute)
+	setModTime(output, 2*time.Minute)
+
+	stale, err := IsStale(output, input1, input2)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if stale {
+		t.Errorf("expected output newer than inputs not to be stale")
+	}
+
+	setModTime(input2, 3*time.Minute)
+
+	stale, err = IsStale(output, input1, input2)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !stale {
+		t.Errorf("expected output older than an input to be stale")
+	}
+
+	stale, err = IsStale(filepath.Join(dir, "missing-output"), input1)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !stale {
+		t.Errorf("expected missing output to be stale")
+	}
+
+	if _, err := IsStale(output, filepath.Join(dir, "missing-input")); err == nil {
+		t.Errorf("expected error for missing input")
+	}
+}
+
+func TestOpenWithinRoot(t *testing.T) {
+	base := t.TempDir()
+	root := filepath.Join(base, "root")
+	outside := filepath.Join(base, "outside")
+	for _, d := range []string{filepath.Join(root, "sub"), outside} {
+		if err := os.MkdirAll(d, 0750); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(root, "sub", "config.yaml"), []byte("inside"), 0600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := os.WriteFile(filepath.Join(outside, "secret"), []byte("outside"), 0600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := os.Symlink(filepath.Join(root, "sub", "config.yaml"), filepath.Join(root, "link.yaml")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := os.Symlink(filepath.Join(outside, "secret"), filepath.Join(root, "escape")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	tests := []struct {
+		name          string
+		path          string
+		expected      string
+		escapeErrored bool
+	}{
+		{name: "relative file", path: "sub/config.yaml", expected: "inside"},
+		{name: "absolute file", path: filepath.Join(root, "sub", "config.yaml"), expected: "inside"},
+		{name: "in-root symlink", path: "link.yaml", expected: "inside"},
+		{name: "escaping symlink", path: "escape", escapeErrored: true},
+		{name: "dot dot", path: "../outside/secret", escapeErrored: true},
+		{name: "absolute outside", path: filepath.Join(outside, "secret"), escapeErrored: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := OpenWithinRoot(root, tt.path)
+			if tt.escapeErrored {
+				if !errors.Is(err, ErrPathEscapesRoot) {
+					t.Fatalf("expected ErrPathEscapesRoot got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			defer f.Close()
+
+			content, err := io.ReadAll(f)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if string(content) != tt.expected {
+				t.Errorf("expected '%s' got '%s'", tt.expected, content)
+			}
+		})
+	}
+}
+
+func TestConvertStructFile(t *testing.T) {
+	dir := t.TempDir()
+	jsonPath := filepath.Join(dir, "config.json")
+	yamlPath := filepath.Join(dir, "config.yaml")
+	roundTripPath := filepath.Join(dir, "roundtrip.json")
+
+	if err := os.WriteFile(jsonPath, []byte(`{"name":"convert","count":3}`), 0600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if err := ConvertStructFile[testConfig](jsonPath, yamlPath); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	content, err := os.ReadFile(yamlPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !strings.Contains(string(content), "name: convert") {
+		t.Errorf("expected yaml output got '%s'", content)
+	}
+
+	if err := ConvertStructFile[testConfig](yamlPath, roundTripPath); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	cfg, err := LoadStructFromFile[testConfig](roundTripPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if cfg.Name != "convert" || cfg.Count != 3 {
+		t.Errorf("expected '{convert 3}' got '%v'", *cfg)
+	}
+
+	if err := ConvertStructFile[testConfig](jsonPath, filepath.Join(dir, "config.txt")); err == nil {
+		t.Errorf("expected error for unsupported destination")
+	}
+}
+
+func TestConvertFile(t *testing.T) {
+	dir := t.TempDir()
+	jsonPath := filepath.Join(dir, "config.json")
+	yamlPath := filepath.Join(dir, "config.yaml")
+	roundTripPath := filepath.Join(dir, "roundtrip.json")
+
+	if err := os.WriteFile(jsonPath, []byte(`{"name":"convert","nested":{"enabled":true},"items":["a","b"]}`), 0600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if err := ConvertFile(jsonPath, yamlPath); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := ConvertFile(yamlPath, roundTripPath); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	data, err := LoadStructFromFile[map[string]any](roundTripPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if (*data)["name"] != "convert" {
+		t.Errorf("expected 'convert' got '%v'", (*data)["name"])
+	}
+	nested, ok := (*data)["nested"].(map[string]any)
+	if !ok || nested["enabled"] != true {
+		t.Errorf("expected nested enabled true got '%v'", (*data)["nested"])
+	}
+	items, ok := (*data)["items"].([]any)
+	if !ok || len(items) != 2 || items[1] != "b" {
+		t.Errorf("expected '[a b]' got '%v'", (*data)["items"])
+	}
+}
+
+func TestDefaultConfigPath(t *testing.T) {
+	home := t.TempDir()
+	setTestHome(t, home)
+
+	tests := []struct {
+		name          string
+		xdgConfigHome string
+		expectedDir   string
+	}{
+		{name: "xdg config home", xdgConfigHome: filepath.Join(home, "xdg"), expectedDir: filepath.Join(home, "xdg")},
+		{name: "home fallback", xdgConfigHome: "", expectedDir: filepath.Join(home, ".config")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("XDG_CONFIG_HOME", tt.xdgConfigHome)
+
+			path, err := DefaultConfigPath("myapp", "config.yaml")
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if !filepath.IsAbs(path) {
+				t.Errorf("expected absolute path got '%s'", path)
+			}
+			if !strings.Contains(path, "myapp") || filepath.Base(path) != "config.yaml" {
+				t.Errorf("expected app name and file name in path got '%s'", path)
+			}
+			if runtime.GOOS == "linux" {
+				expected := filepath.Join(tt.expectedDir, "myapp", "config.yaml")
+				if path != expected {
+					t.Errorf("expected '%s' got '%s'", expected, path)
+				}
+			}
+		})
+	}
+
+	if _, err := DefaultConfigPath("", "config.yaml"); err == nil {
+		t.Errorf("expected error for empty app name")
+	}
+}
+
+func TestSaveStructToFileOmitEmpty(t *testing.T) {
+	data := map[string]any{
+		"name":    "app",
+		"empty":   "",
+		"zero":    0,
+		"false":   false,
+		"nil":     nil,
+		"count":   2,
+		"tags":    []any{},
+		"servers": []any{"a", ""},
+		"nested": map[string]any{
+			"keep":  "yes",
+			"drop":  "",
+			"inner": map[string]any{"drop": nil},
+		},
+		"allEmpty": map[string]any{"drop": ""},
+	}
+
+	expected := map[string]any{
+		"name":    "app",
+		"count":   float64(2),
+		"servers": []any{"a", ""},
+		"nested":  map[string]any{"keep": "yes"},
+	}
+
+	for _, ext := range []string{".json", ".yaml"} {
+		t.Run(ext, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config"+ext)
+			if err := SaveStructToFileOmitEmpty(&data, path); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			loaded, err := LoadStructFromFile[map[string]any](path)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if ext == ".yaml" {
+				(*loaded)["count"] = float64((*loaded)["count"].(int))
+			}
+			if fmt.Sprint(*loaded) != fmt.Sprint(expected) {
+				t.Errorf("expected '%v' got '%v'", expected, *loaded)
+			}
+		})
+	}
+
+	if _, ok := data["empty"]; !ok {
+		t.Errorf("expected original map to be unmodified")
+	}
+}
+
+func TestSaveStructToFileOmitEmptyAllEmpty(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	data := map[string]any{"empty": ""}
+	if err := SaveStructToFileOmitEmpty(&data, path); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if strings.TrimSpace(string(content)) != "{}" {
+		t.Errorf("expected '{}' got '%s'", content)
+	}
+}
+
+func TestSaveStructToFileOmitEmptySliceOfMaps(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     any
+		expected string
+	}{
+		{name: "top level slice", data: []any{map[string]any{"a": ""}}, expected: `[{}]`},
+		{name: "nested slice", data: map[string]any{"list": []any{map[string]any{"a": ""}, map[string]any{"a": "", "b": "keep"}}}, expected: `{"list":[{},{"b":"keep"}]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.json")
+			if err := SaveStructToFileOmitEmpty(&tt.data, path); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			content, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if strings.TrimSpace(string(content)) != tt.expected {
+				t.Errorf("expected '%s' got '%s'", tt.expected, content)
+			}
+		})
+	}
+}
+
+func TestSaveStructToFileOmitEmptyStruct(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := SaveStructToFileOmitEmpty(&testConfig{Name: "app"}, path); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !strings.Contains(string(content), `"count":0`) {
+		t.Errorf("expected untagged struct fields to be kept got '%s'", content)
+	}
+}
+
+func TestIsSupportedConfigFile(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected bool
+	}{
+		{path: "config.json", expected: true},
+		{path: "config.yaml", expected: true},
+		{path: "/etc/app/config.yml", expected: true},
+		{path: "CONFIG.JSON", expected: true},
+		{path: "config.YAML", expected: true},
+		{path: "config.Yml", expected: true},
+		{path: "records.jsonl", expected: true},
+		{path: "records.NDJSON", expected: true},
+		{path: "config.toml", expected: false},
+		{path: "config.json.bak", expected: false},
+		{path: "config", expected: false},
+		{path: "json", expected: false},
+		{path: "", expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := IsSupportedConfigFile(tt.path); got != tt.expected {
+				t.Errorf("expected %v got %v", tt.expected, got)
+			}
+		})
+	}
+
+	for _, format := range SupportedFormats() {
+		if !IsSupportedConfigFile("config." + format) {
+			t.Errorf("expected supported format %v to be accepted", format)
+		}
+	}
+}
+
+func TestLoadStructFromFileUppercaseExtension(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "CONFIG.YAML")
+	if err := SaveStructToFile(&testConfig{Name: "upper", Count: 1}, path); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	cfg, err := LoadStructFromFile[testConfig](path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if cfg.Name != "upper" {
+		t.Errorf("expected 'upper' got '%s'", cfg.Name)
+	}
+}
+
+func TestResolveExistingFiles(t *testing.T) {
+	home := t.TempDir()
+	setTestHome(t, home)
+
+	dataDir := t.TempDir()
+	t.Setenv("RESOLVE_DATA_DIR", dataDir)
+
+	for _, path := range []string{filepath.Join(home, "home.yaml"), filepath.Join(dataDir, "data.json")} {
+		if err := os.WriteFile(path, []byte("{}"), 0600); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	resolved, err := ResolveExistingFiles("~/home.yaml", "$RESOLVE_DATA_DIR/data.json")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := []string{filepath.Join(home, "home.yaml"), filepath.Join(dataDir, "data.json")}
+	if strings.Join(resolved, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected '%v' got '%v'", expected, resolved)
+	}
+
+	_, err = ResolveExistingFiles("~/home.yaml", "~/missing.yaml", "$RESOLVE_DATA_DIR/missing.json")
+	if err == nil {
+		t.Fatalf("expected error for missing files")
+	}
+	for _, missing := range []string{filepath.Join(home, "missing.yaml"), filepath.Join(dataDir, "missing.json")} {
+		if !strings.Contains(err.Error(), missing) {
+			t.Errorf("expected error to name '%s' got '%s'", missing, err)
+		}
+	}
+	if strings.Contains(err.Error(), "home.yaml") {
+		t.Errorf("expected error to name only missing files got '%s'", err)
+	}
+}
+
+func TestWaitForFilesStrict(t *testing.T) {
+	tests := []struct {
+		name             string
+		statErrs         []error
+		maxTries         uint
+		expectedAttempts int
+		expectedErr      error
+		errorExpected    bool
+	}{
+		{name: "exists", statErrs: []error{nil}, maxTries: 5, expectedAttempts: 1},
+		{name: "not exist retries until present", statErrs: []error{fs.ErrNotExist, fs.ErrNotExist, nil}, maxTries: 5, expectedAttempts: 3},
+		{name: "not exist retries until timeout", statErrs: []error{fs.ErrNotExist, fs.ErrNotExist, fs.ErrNotExist}, maxTries: 3, expectedAttempts: 3, errorExpected: true},
+		{name: "permission fails immediately", statErrs: []error{fs.ErrNotExist, fs.ErrPermission, nil}, maxTries: 5, expectedAttempts: 2, expectedErr: fs.ErrPermission, errorExpected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			stat := func(path string) (os.FileInfo, error) {
+				err := tt.statErrs[attempts]
+				attempts++
+				if err != nil {
+					return nil, &fs.PathError{Op: "stat", Path: path, Err: err}
+				}
+				return nil, nil
+			}
+
+			err := waitForFilesStrict(context.Background(), newFakeClock(), stat, time.Second, tt.maxTries, "file")
+			if tt.errorExpected && err == nil {
+				t.Errorf("expected error")
+			}
+			if !tt.errorExpected && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			if tt.expectedErr != nil && !errors.Is(err, tt.expectedErr) {
+				t.Errorf("expected '%v' got '%v'", tt.expectedErr, err)
+			}
+			if attempts != tt.expectedAttempts {
+				t.Errorf("expected %d attempts got %d", tt.expectedAttempts, attempts)
+			}
+		})
+	}
+}
+
+func TestWaitForFilesStrictPermission(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("permission checks are bypassed when running as root")
+	}
+
+	dir := filepath.Join(t.TempDir(), "locked")
+	if err := os.Mkdir(dir, 0700); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "file"), []byte{}, 0600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := os.Chmod(dir, 0); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	t.Cleanup(func() { _ = os.Chmod(dir, 0700) })
+
+	start := time.Now()
+	err := WaitForFilesStrict(context.Background(), time.Second, 10, filepath.Join(dir, "file"))
+	if !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("expected permission error got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("expected to fail fast, took %s", elapsed)
+	}
+}
+
+func TestFilesExistStrict(t *testing.T) {
+	dir := t.TempDir()
+	present := filepath.Join(dir, "present")
+	if err := os.WriteFile(present, []byte{}, 0600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	exist, err := FilesExistStrict(present)
+	if err != nil || !exist {
+		t.Errorf("expected true got %v, %v", exist, err)
+	}
+
+	exist, err = FilesExistStrict(present, filepath.Join(dir, "missing"))
+	if err != nil || exist {
+		t.Errorf("expected false got %v, %v", exist, err)
+	}
+}
+
+func TestIsZero(t *testing.T) {
+	name := "name"
+	emptyName := ""
+
+	tests := []struct {
+		name     string
+		isZero   func() bool
+		expected bool
+	}{
+		{name: "zero int", isZero: func() bool { return IsZero(0) }, expected: true},
+		{name: "int", isZero: func() bool { return IsZero(42) }, expected: false},
+		{name: "zero string", isZero: func() bool { return IsZero("") }, expected: true},
+		{name: "string", isZero: func() bool { return IsZero("a") }, expected: false},
+		{name: "zero struct", isZero: func() bool { return IsZero(testConfig{}) }, expected: true},
+		{name: "struct", isZero: func() bool { return IsZero(testConfig{Count: 1}) }, expected: false},
+		{name: "nil pointer", isZero: func() bool { return IsZero[*string](nil) }, expected: true},
+		{name: "pointer", isZero: func() bool { return IsZero(&name) }, expected: false},
+		{name: "pointer to zero value", isZero: func() bool { return IsZero(&emptyName) }, expected: false},
+		{name: "nil slice", isZero: func() bool { return IsZero[[]string](nil) }, expected: true},
+		{name: "empty slice", isZero: func() bool { return IsZero([]string{}) }, expected: false},
+		{name: "nil map", isZero: func() bool { return IsZero[map[string]any](nil) }, expected: true},
+		{name: "nil any", isZero: func() bool { return IsZero[any](nil) }, expected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.isZero(); got != tt.expected {
+				t.Errorf("expected %v got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestCleanOpenMaybeGzip(t *testing.T) {
+	dir := t.TempDir()
+	content := "line one\nline two\n"
+
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	if _, err := gz.Write([]byte(content)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	files := map[string][]byte{
+		"plain.log":       []byte(content),
+		"app.log.gz":      compressed.Bytes(),
+		"no-extension":    compressed.Bytes(),
+		"empty.log":       {},
+		"single-byte.log": []byte("x"),
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{name: "plain.log", expected: content},
+		{name: "app.log.gz", expected: content},
+		{name: "no-extension", expected: content},
+		{name: "empty.log", expected: ""},
+		{name: "single-byte.log", expected: "x"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := CleanOpenMaybeGzip(filepath.Join(dir, tt.name))
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			data, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if string(data) != tt.expected {
+				t.Errorf("expected '%s' got '%s'", tt.expected, data)
+			}
+			if err := r.Close(); err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+		})
+	}
+
+	if _, err := CleanOpenMaybeGzip(filepath.Join(dir, "plain.log.gz")); err == nil {
+		t.Errorf("expected error for missing file")
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "invalid.gz"), []byte(content), 0600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := CleanOpenMaybeGzip(filepath.Join(dir, "invalid.gz")); err == nil {
+		t.Errorf("expected error for invalid gzip content")
+	}
+}
+
+func TestLoadStructFromFileInLocation(t *testing.T) {
+	type window struct {
+		Start time.Time `yaml:"start" json:"start"`
+	}
+	type schedule struct {
+		Cutoff  time.Time            `yaml:"cutoff" json:"cutoff"`
+		Offset  time.Time            `yaml:"offset" json:"offset"`
+		At      time.Time            `yaml:"at" json:"at"`
+		Label   string               `yaml:"label" json:"label"`
+		Windows []window             `yaml:"windows" json:"windows"`
+		Named   map[string]time.Time `yaml:"named" json:"named"`
+		Next    *time.Time           `yaml:"next" json:"next"`
+	}
+
+	loc := time.FixedZone("EST", -5*60*60)
+	dir := t.TempDir()
+
+	yamlPath := filepath.Join(dir, "schedule.yaml")
+	yamlContent := "cutoff: 2024-03-01 12:00:00\noffset: 2024-03-01T12:00:00+02:00\nat: 2024-01-01T10:00:00Z\nlabel: 2024-03-01 12:00:00\nwindows:\n  - start: 2024-03-02 08:30:00\nnamed:\n  launch: 2024-03-03 09:00:00\nnext: 2024-03-04\n"
+	if err := os.WriteFile(yamlPath, []byte(yamlContent), 0600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	s, err := LoadStructFromFileInLocation[schedule](yamlPath, loc)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := map[string]struct {
+		got      time.Time
+		expected time.Time
+	}{
+		"cutoff": {got: s.Cutoff, expected: time.Date(2024, 3, 1, 12, 0, 0, 0, loc)},
+		"window": {got: s.Windows[0].Start, expected: time.Date(2024, 3, 2, 8, 30, 0, 0, loc)},
+		"named":  {got: s.Named["launch"], expected: time.Date(2024, 3, 3, 9, 0, 0, 0, loc)},
+		"next":   {got: *s.Next, expected: time.Date(2024, 3, 4, 0, 0, 0, 0, loc)},
+		"offset": {got: s.Offset, expected: time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)},
+		"at":     {got: s.At, expected: time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)},
+	}
+	for name, e := range expected {
+		if !e.got.Equal(e.expected) {
+			t.Errorf("%s: expected '%s' got '%s'", name, e.expected, e.got)
+		}
+	}
+	if s.Cutoff.Location() != loc {
+		t.Errorf("expected location %v got %v", loc, s.Cutoff.Location())
+	}
+	if s.At.Location() != time.UTC {
+		t.Errorf("expected explicit UTC time to stay UTC got %v", s.At.Location())
+	}
+	if s.Label != "2024-03-01 12:00:00" {
+		t.Errorf("expected string field to be unchanged got '%s'", s.Label)
+	}
+
+	jsonPath := filepath.Join(dir, "schedule.json")
+	if err := os.WriteFile(jsonPath, []byte(`{"cutoff": "2024-03-01T12:00:00Z"}`), 0600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	s, err = LoadStructFromFileInLocation[schedule](jsonPath, loc)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if expectedCutoff := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC); !s.Cutoff.Equal(expectedCutoff) {
+		t.Errorf("expected json time to be unchanged '%s' got '%s'", expectedCutoff, s.Cutoff)
+	}
+
+	absentPath := filepath.Join(dir, "absent.yaml")
+	if err := os.WriteFile(absentPath, []byte("cutoff: 2024-03-01 12:00:00\n"), 0600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	s, err = LoadStructFromFileInLocation[schedule](absentPath, loc)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !s.Offset.IsZero() {
+		t.Errorf("expected time absent from the file to stay zero got '%s'", s.Offset)
+	}
+}
+
+func TestCleanOpenAppend(t *testing.T) {
+	home := t.TempDir()
+	setTestHome(t, home)
+
+	path := "~/logs/audit.log"
+	expandedPath := filepath.Join(home, "logs", "audit.log")
+
+	for _, line := range []string{"first\n", "second\n"} {
+		f, err := CleanOpenAppend(path, 0600)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if _, err := f.WriteString(line); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	content, err := os.ReadFile(expandedPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(content) != "first\nsecond\n" {
+		t.Errorf("expected 'first\\nsecond\\n' got '%s'", content)
+	}
+
+	info, err := os.Stat(expandedPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm() != 0600 {
+		t.Errorf("expected mode 0600 got %v", info.Mode().Perm())
+	}
+}
+
+func TestValidateConfigFile(t *testing.T) {
+	tests := []struct {
+		name          string
+		file          string
+		content       string
+		errorContains string
+	}{
+		{name: "valid yaml", file: "config.yaml", content: "name: app\nserver:\n  port: 8080\n"},
+		{name: "valid json", file: "config.json", content: "{\"name\": \"app\", \"server\": {\"port\": 8080}}\n"},
+		{name: "empty yaml", file: "empty.yml", content: ""},
+		{name: "bad yaml indentation", file: "bad.yaml", content: "name: app\nserver:\n  port: 8080\n   host: local\n", errorContains: "line 4"},
+		{name: "bad json", file: "bad.json", content: "{\n  \"name\": \"app\",\n  \"port\": 8080,\n}\n", errorContains: "line 4"},
+		{name: "truncated json", file: "truncated.json", content: "{\"name\": \"app\"", errorContains: "invalid json"},
+		{name: "trailing json", file: "trailing.json", content: "{\"name\": \"app\"}\n{}", errorContains: "line 2"},
+		{name: "valid jsonl", file: "records.jsonl", content: "{\"name\": \"a\"}\n\n{\"name\": \"b\"}\n"},
+		{name: "bad ndjson", file: "records.ndjson", content: "{\"name\": \"a\"}\n{\"name\": }\n", errorContains: "line 2"},
+		{name: "unsupported", file: "config.toml", content: "name = \"app\"", errorContains: "unrecognised file type"},
+	}
+
+	dir := t.TempDir()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.file)
+			if err := os.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			err := ValidateConfigFile(path)
+			if tt.errorContains == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %s", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.errorContains) {
+				t.Errorf("expected error containing '%s' got '%v'", tt.errorContains, err)
+			}
+		})
+	}
+}
+
+func TestOpenRelative(t *testing.T) {
+	base := t.TempDir()
+	pluginDir := filepath.Join(base, "plugins", "example")
+	if err := os.MkdirAll(filepath.Join(pluginDir, "templates"), 0750); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	baseFile := filepath.Join(pluginDir, "plugin.yaml")
+	files := map[string]string{
+		baseFile: "name: example",
+		filepath.Join(pluginDir, "templates", "page.tmpl"): "sibling",
+		filepath.Join(base, "secret"):                      "secret",
+	}
+	for path, content := range files {
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	f, err := OpenRelative(baseFile, "templates/../templates/page.tmpl")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	content, err := io.ReadAll(f)
+	_ = f.Close()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(content) != "sibling" {
+		t.Errorf("expected 'sibling' got '%s'", content)
+	}
+
+	if _, err := OpenRelative(baseFile, "../../secret"); !errors.Is(err, ErrPathEscapesRoot) {
+		t.Errorf("expected ErrPathEscapesRoot got %v", err)
+	}
+}
+
+func TestNewestMatchingFile(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+
+	files := map[string]time.Duration{
+		"app-1.log":   -3 * time.Hour,
+		"app-2.log":   -1 * time.Hour,
+		"app-3.log":   -2 * time.Hour,
+		"other-0.log": 0,
+	}
+	for name, age := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(name), 0600); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if err := os.Chtimes(path, now.Add(age), now.Add(age)); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	newest, err := NewestMatchingFile(filepath.Join(dir, "app-*.log"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if expected := filepath.Join(dir, "app-2.log"); newest != expected {
+		t.Errorf("expected '%s' got '%s'", expected, newest)
+	}
+
+	if _, err := NewestMatchingFile(filepath.Join(dir, "missing-*.log")); err == nil {
+		t.Errorf("expected error when nothing matches")
+	}
+	if _, err := NewestMatchingFile(filepath.Join(dir, "[")); err == nil {
+		t.Errorf("expected error for malformed pattern")
+	}
+}
+
+func TestReadFileLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "allowlist")
+	content := "# allowed hosts\nexample.com  \n\n\t api.example.com\n   \n  # disabled.example.com\nlast.example.com"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	tests := []struct {
+		name     string
+		opts     ReadFileLinesOptions
+		expected []string
+	}{
+		{name: "defaults", expected: []string{"example.com", "api.example.com", "last.example.com"}},
+		{name: "keep comments", opts: ReadFileLinesOptions{KeepComments: true}, expected: []string{"# allowed hosts", "example.com", "api.example.com", "# disabled.example.com", "last.example.com"}},
+		{name: "keep blank", opts: ReadFileLinesOptions{KeepBlank: true}, expected: []string{"example.com", "", "api.example.com", "", "last.example.com"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines, err := ReadFileLinesWithOptions(path, tt.opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if strings.Join(lines, "|") != strings.Join(tt.expected, "|") {
+				t.Errorf("expected '%q' got '%q'", tt.expected, lines)
+			}
+		})
+	}
+
+	lines, err := ReadFileLines(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(lines) != 3 {
+		t.Errorf("expected 3 lines got %q", lines)
+	}
+
+	if _, err := ReadFileLines(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Errorf("expected error for missing file")
+	}
+}
+
+type failingReader struct {
+	data []byte
+	err  error
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, r.err
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestWriteReaderAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "nested", "data.bin")
+
+	n, err := WriteReaderAtomic(path, bytes.NewReader([]byte("first content")), 0640)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if n != int64(len("first content")) {
+		t.Errorf("expected %d bytes got %d", len("first content"), n)
+	}
+
+	n, err = WriteReaderAtomic(path, bytes.NewReader([]byte("second")), 0640)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if n != 6 {
+		t.Errorf("expected 6 bytes got %d", n)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(content) != "second" {
+		t.Errorf("expected 'second' got '%s'", content)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm() != 0640 {
+		t.Errorf("expected mode 0640 got %v", info.Mode().Perm())
+	}
+}
+
+func TestWriteReaderAtomicCopyError(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data.bin")
+	if err := os.WriteFile(path, []byte("original"), 0600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	errRead := errors.New("read failed")
+	_, err := WriteReaderAtomic(path, &failingReader{data: []byte("partial"), err: errRead}, 0600)
+	if !errors.Is(err, errRead) {
+		t.Errorf("expected '%v' got '%v'", errRead, err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(content) != "original" {
+		t.Errorf("expected 'original' got '%s'", content)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected temporary file to be removed got %d entries", len(entries))
+	}
+}
+
+func TestCreateDirIfParentExists(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "file"), []byte{}, 0600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	created, err := CreateDirIfParentExists(filepath.Join(dir, "leaf"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if info, err := os.Stat(created); err != nil || !info.IsDir() {
+		t.Errorf("expected '%s' to be a directory got %v", created, err)
+	}
+
+	if _, err := CreateDirIfParentExists(filepath.Join(dir, "leaf")); err != nil {
+		t.Errorf("expected existing directory to be accepted got %s", err)
+	}
+
+	missingParent := filepath.Join(dir, "typo", "deeper", "leaf")
+	if _, err := CreateDirIfParentExists(missingParent); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected not exist error got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "typo")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected no directories to be created got %v", err)
+	}
+
+	if _, err := CreateDirIfParentExists(filepath.Join(dir, "file", "leaf")); err == nil {
+		t.Errorf("expected error when parent is a file")
+	}
+	if _, err := CreateDirIfParentExists(filepath.Join(dir, "file")); err == nil {
+		t.Errorf("expected error when path is an existing file")
+	}
+}
+
+func TestExpandPathSplit(t *testing.T) {
+	home := t.TempDir()
+	setTestHome(t, home)
+	t.Setenv("SPLIT_DIR", filepath.Join(home, "data"))
+	t.Setenv("SPLIT_FILE", "config.yaml")
+
+	tests := []struct {
+		path         string
+		expectedDir  string
+		expectedFile string
+	}{
+		{path: "~/app/config.yaml", expectedDir: filepath.Join(home, "app"), expectedFile: "config.yaml"},
+		{path: "$SPLIT_DIR/nested/../$SPLIT_FILE", expectedDir: filepath.Join(home, "data"), expectedFile: "config.yaml"},
+		{path: "~/", expectedDir: filepath.Dir(home), expectedFile: filepath.Base(home)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			dir, file, err := ExpandPathSplit(tt.path)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if dir != tt.expectedDir {
+				t.Errorf("expected dir '%s' got '%s'", tt.expectedDir, dir)
+			}
+			if file != tt.expectedFile {
+				t.Errorf("expected file '%s' got '%s'", tt.expectedFile, file)
+			}
+		})
+	}
+}
+
+func TestLoadStructFromFileAtKey(t *testing.T) {
+	dir := t.TempDir()
+
+	yamlPath := filepath.Join(dir, "shared.yaml")
+	yamlContent := "logging:\n  level: info\nservices:\n  other:\n    name: other\n  myapp:\n    name: myapp\n    count: 3\n"
+	jsonPath := filepath.Join(dir, "shared.json")
+	jsonContent := `{"services": {"other": {"name": "other"}, "myapp": {"name": "myapp", "count": 3}}}`
+	for path, content := range map[string]string{yamlPath: yamlContent, jsonPath: jsonContent} {
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	for _, path := range []string{yamlPath, jsonPath} {
+		t.Run(filepath.Ext(path), func(t *testing.T) {
+			cfg, err := LoadStructFromFileAtKey[testConfig](path, "services.myapp")
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if cfg.Name != "myapp" || cfg.Count != 3 {
+				t.Errorf("expected {myapp 3} got %v", *cfg)
+			}
+
+			for _, key := range []string{"services.missing", "services.myapp.name.deeper", "missing"} {
+				if _, err := LoadStructFromFileAtKey[testConfig](path, key); err == nil {
+					t.Errorf("expected error for key '%s'", key)
+				}
+			}
+		})
+	}
+
+	if _, err := LoadStructFromFileAtKey[testConfig](filepath.Join(dir, "shared.toml"), "services"); err == nil {
+		t.Errorf("expected error for unrecognised extension")
+	}
+
+	jsonlPath := filepath.Join(dir, "shared.jsonl")
+	if err := os.WriteFile(jsonlPath, []byte(`{"services": {"name": "svc", "count": 1}}`+"\n"), 0600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := LoadStructFromFileAtKey[testConfig](jsonlPath, "services"); err == nil {
+		t.Errorf("expected error for JSON Lines file")
+	}
+}
+
+func TestPrettyPath(t *testing.T) {
+	base := t.TempDir()
+	home := filepath.Join(base, "home")
+	setTestHome(t, home)
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{path: filepath.Join(home, "app", "config.yaml"), expected: filepath.Join("~", "app", "config.yaml")},
+		{path: "~/app/../config.yaml", expected: filepath.Join("~", "config.yaml")},
+		{path: home, expected: "~"},
+		{path: "~", expected: "~"},
+		{path: filepath.Join(base, "other", "config.yaml"), expected: filepath.Join(base, "other", "config.yaml")},
+		{path: home + "-sibling", expected: home + "-sibling"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			pretty, err := PrettyPath(tt.path)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if pretty != tt.expected {
+				t.Errorf("expected '%s' got '%s'", tt.expected, pretty)
+			}
+		})
+	}
+}
+
+func TestLoadStructsFromDirMap(t *testing.T) {
+	dir := t.TempDir()
+
+	files := map[string]string{
+		"tenant-a.yaml": "name: a\ncount: 1\n",
+		"tenant-b.json": `{"name": "b", "count": 2}`,
+		"README.md":     "not a config file",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "nested.yaml"), 0700); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	items, err := LoadStructsFromDirMap[testConfig](dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := map[string]testConfig{
+		"tenant-a": {Name: "a", Count: 1},
+		"tenant-b": {Name: "b", Count: 2},
+	}
+	if len(items) != len(expected) {
+		t.Fatalf("expected %d items got %d: %v", len(expected), len(items), items)
+	}
+	for key, want := range expected {
+		got, ok := items[key]
+		if !ok {
+			t.Errorf("expected key '%s'", key)
+			continue
+		}
+		if *got != want {
+			t.Errorf("expected '%v' got '%v' for key '%s'", want, *got, key)
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "tenant-a.json"), []byte(`{"name": "a"}`), 0600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := LoadStructsFromDirMap[testConfig](dir); err == nil {
+		t.Errorf("expected error for duplicate key")
+	}
+
+	if _, err := LoadStructsFromDirMap[testConfig](filepath.Join(dir, "missing")); err == nil {
+		t.Errorf("expected error for missing directory")
+	}
+}
+
+func TestSecureDelete(t *testing.T) {
+	dir := t.TempDir()
+
+	path := filepath.Join(dir, "secret.txt")
+	content := bytes.Repeat([]byte("s3cr3t"), 10000)
+	if err := os.WriteFile(path, content, 0600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// a hard link keeps the underlying data reachable so the overwrite can be checked
+	linkPath := filepath.Join(dir, "secret-link.txt")
+	if err := os.Link(path, linkPath); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if err := SecureDelete(path); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected file to be removed got %v", err)
+	}
+
+	overwritten, err := os.ReadFile(linkPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(overwritten) != len(content) {
+		t.Errorf("expected length %d got %d", len(content), len(overwritten))
+	}
+	if !bytes.Equal(overwritten, make([]byte, len(content))) {
+		t.Errorf("expected contents to be overwritten with zeros")
+	}
+
+	if err := SecureDelete(filepath.Join(dir, "missing.txt")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected os.ErrNotExist got %v", err)
+	}
+	if err := SecureDelete(dir); err == nil {
+		t.Errorf("expected error for directory")
+	}
+}
+
+func TestSecureDeleteSymlink(t *testing.T) {
+	dir := t.TempDir()
+
+	targetPath := filepath.Join(dir, "id_rsa")
+	content := []byte("private key")
+	if err := os.WriteFile(targetPath, content, 0600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	linkPath := filepath.Join(dir, "secret-link")
+	if err := os.Symlink(targetPath, linkPath); err != nil {
+		t.Skipf("symlinks not supported: %s", err)
+	}
+
+	if err := SecureDelete(linkPath); err == nil {
+		t.Errorf("expected error for symlink")
+	}
+
+	got, err := os.ReadFile(targetPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Errorf("expected symlink target to be untouched got '%s'", got)
+	}
+	if _, err := os.Lstat(linkPath); err != nil {
+		t.Errorf("expected symlink to be left in place got %v", err)
+	}
+}
+
+func TestRenderFileTemplateToFile(t *testing.T) {
+	dir := t.TempDir()
+
+	templatePath := filepath.Join(dir, "config.yaml.tmpl")
+	if err := os.WriteFile(templatePath, []byte("name: {{ .Name }}\ncount: {{ .Count }}\n"), 0600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	outPath := filepath.Join(dir, "out", "config.yaml")
+	if err := RenderFileTemplateToFile(templatePath, outPath, testConfig{Name: "rendered", Count: 7}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	content, err := os.ReadFile(outPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if expected := "name: rendered\ncount: 7\n"; string(content) != expected {
+		t.Errorf("expected '%s' got '%s'", expected, content)
+	}
+
+	brokenPath := filepath.Join(dir, "broken.tmpl")
+	if err := os.WriteFile(brokenPath, []byte("name: {{ .Missing"), 0600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := RenderFileTemplateToFile(brokenPath, outPath, testConfig{}); err == nil {
+		t.Errorf("expected error for invalid template")
+	}
+	if content, _ := os.ReadFile(outPath); string(content) != "name: rendered\ncount: 7\n" {
+		t.Errorf("expected output to be unchanged got '%s'", content)
+	}
+
+	if err := RenderFileTemplateToFile(filepath.Join(dir, "missing.tmpl"), outPath, nil); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected os.ErrNotExist got %v", err)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package util
+
+import (
+	"os"
+)
+
+// lockFile is a no-op where advisory file locks aren't supported, writers within the process are still
+// serialised by callers.
+func lockFile(_ *os.File) (func() error, error) {
+	return func() error { return nil }, nil
+}
@@ -0,0 +1,19 @@
+//go:build unix
+
+package util
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile takes an exclusive advisory lock on f, blocking until it is available, and returns a function that
+// releases it.
+func lockFile(f *os.File) (func() error, error) {
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		return nil, err
+	}
+	return func() error {
+		return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+	}, nil
+}
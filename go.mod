@@ -4,5 +4,8 @@ go 1.22.1
 
 require (
 	github.com/dioad/generics v0.0.5
+	github.com/go-viper/mapstructure/v2 v2.4.0
 	github.com/mitchellh/go-homedir v1.1.0
+	golang.org/x/sync v0.9.0
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -0,0 +1,211 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var errRetryableResponse = errors.New("retryable response")
+
+// RetryTransport is an http.RoundTripper that retries idempotent requests using WaitForReturn semantics,
+// waiting Interval between up to MaxTries attempts. Request bodies are buffered so they can be replayed.
+type RetryTransport struct {
+	// Base is the underlying transport, http.DefaultTransport if nil
+	Base     http.RoundTripper
+	Interval time.Duration
+	MaxTries uint
+	// Retryable decides whether an attempt should be retried, DefaultRetryable if nil
+	Retryable func(*http.Response, error) bool
+}
+
+// NewRetryTransport returns a RetryTransport that retries idempotent requests on connection errors and 5xx responses.
+// The interval isn't validated here: if it isn't positive and maxTries is more than 1, each idempotent request
+// fails with ErrInvalidInterval.
+func NewRetryTransport(base http.RoundTripper, interval time.Duration, maxTries uint) *RetryTransport {
+	return &RetryTransport{
+		Base:     base,
+		Interval: interval,
+		MaxTries: maxTries,
+	}
+}
+
+// DefaultRetryable retries on transport errors and 5xx responses.
+func DefaultRetryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	return resp.StatusCode >= 500
+}
+
+func isIdempotentRequest(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return req.Header.Get("Idempotency-Key") != ""
+}
+
+type roundTripResult struct {
+	resp *http.Response
+	err  error
+}
+
+// RoundTrip implements http.RoundTripper. Non-idempotent requests are sent once. When all attempts are
+// exhausted the last response or error is returned.
+func (t *RetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	if !isIdempotentRequest(req) {
+		return base.RoundTrip(req)
+	}
+
+	retryable := t.Retryable
+	if retryable == nil {
+		retryable = DefaultRetryable
+	}
+
+	getBody, err := replayableBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	maxTries := t.MaxTries
+	if maxTries == 0 {
+		maxTries = 1
+	}
+
+	var attempt uint
+	result, err := waitForReturn(req.Context(), realClock{}, t.Interval, maxTries, func() (*roundTripResult, error) {
+		attempt++
+
+		attemptReq := req.Clone(req.Context())
+		if getBody != nil {
+			body, err := getBody()
+			if err != nil {
+				return &roundTripResult{err: err}, nil
+			}
+			attemptReq.Body = body
+		}
+
+		resp, err := base.RoundTrip(attemptReq)
+		if attempt < maxTries && retryable(resp, err) {
+			if resp != nil {
+				_, _ = io.Copy(io.Discard, resp.Body)
+				_ = resp.Body.Close()
+			}
+			if err == nil {
+				err = fmt.Errorf("%w: %v", errRetryableResponse, resp.Status)
+			}
+			return nil, err
+		}
+		return &roundTripResult{resp: resp, err: err}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.resp, result.err
+}
+
+// replayableBody returns a function that produces a fresh copy of the request body for each attempt,
+// buffering the body in memory if the request doesn't already provide GetBody.
+func replayableBody(req *http.Request) (func() (io.ReadCloser, error), error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	if req.GetBody != nil {
+		// RoundTrip must always close the request body, even though each attempt sends a copy from GetBody
+		if err := req.Body.Close(); err != nil {
+			return nil, err
+		}
+		return req.GetBody, nil
+	}
+
+	data, err := io.ReadAll(req.Body)
+	closeErr := req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if closeErr != nil {
+		return nil, closeErr
+	}
+
+	return func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}, nil
+}
+
+// decoderFuncFromContentType returns the decoder for a json or yaml media type, ignoring any parameters
+func decoderFuncFromContentType(contentType string) decoderFunc {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil
+	}
+
+	switch {
+	case mediaType == "application/json", strings.HasSuffix(mediaType, "+json"):
+		return jsonDecoderFunc
+	case mediaType == "application/yaml", mediaType == "application/x-yaml", mediaType == "text/yaml",
+		mediaType == "text/x-yaml", strings.HasSuffix(mediaType, "+yaml"):
+		return yamlDecoderFunc
+	default:
+		return nil
+	}
+}
+
+// LoadStructFromURL loads a struct from a yaml/yml or json document fetched with a GET request to rawURL.
+// The format is inferred from the extension of the URL path, falling back to the response's Content-Type.
+// A non-2xx response is an error, and the request is cancelled if ctx is done.
+func LoadStructFromURL[T any](ctx context.Context, rawURL string) (*T, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := loadStructFromResponse[T](u, resp)
+	if err != nil {
+		closeErr := resp.Body.Close()
+		if closeErr != nil {
+			return nil, fmt.Errorf("%w: %v", err, closeErr)
+		}
+		return nil, err
+	}
+
+	return data, resp.Body.Close()
+}
+
+func loadStructFromResponse[T any](u *url.URL, resp *http.Response) (*T, error) {
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("unexpected status fetching %v: %v", RedactURL(u), resp.Status)
+	}
+
+	decFunc := decoderFuncFromFilePath(u.Path)
+	if decFunc == nil {
+		decFunc = decoderFuncFromContentType(resp.Header.Get("Content-Type"))
+	}
+	if decFunc == nil {
+		return nil, fmt.Errorf("unrecognised content type %v. expected a json or yaml media type", resp.Header.Get("Content-Type"))
+	}
+
+	return loadStructFromReaderWithDecoder[T](resp.Body, decFunc)
+}
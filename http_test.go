@@ -0,0 +1,261 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type failingServer struct {
+	mu       sync.Mutex
+	failures int
+	attempts int
+	bodies   []string
+}
+
+func (s *failingServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attempts++
+	s.bodies = append(s.bodies, string(body))
+
+	if s.attempts <= s.failures {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	_, _ = w.Write([]byte("ok"))
+}
+
+func TestRetryTransport(t *testing.T) {
+	handler := &failingServer{failures: 2}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	client := &http.Client{Transport: NewRetryTransport(http.DefaultTransport, time.Millisecond, 5)}
+
+	req, err := http.NewRequest(http.MethodPut, server.URL, io.NopCloser(strings.NewReader("payload")))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "ok" {
+		t.Errorf("expected 200 ok got %d %s", resp.StatusCode, body)
+	}
+	if handler.attempts != 3 {
+		t.Errorf("expected 3 attempts got %d", handler.attempts)
+	}
+	for i, b := range handler.bodies {
+		if b != "payload" {
+			t.Errorf("expected body 'payload' on attempt %d got '%s'", i+1, b)
+		}
+	}
+}
+
+func TestRetryTransportExhausted(t *testing.T) {
+	handler := &failingServer{failures: 10}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	client := &http.Client{Transport: NewRetryTransport(nil, time.Millisecond, 3)}
+
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected last response 503 got %d", resp.StatusCode)
+	}
+	if handler.attempts != 3 {
+		t.Errorf("expected 3 attempts got %d", handler.attempts)
+	}
+}
+
+func TestRetryTransportNonIdempotent(t *testing.T) {
+	handler := &failingServer{failures: 2}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	client := &http.Client{Transport: NewRetryTransport(nil, time.Millisecond, 5)}
+
+	resp, err := client.Post(server.URL, "text/plain", strings.NewReader("payload"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 got %d", resp.StatusCode)
+	}
+	if handler.attempts != 1 {
+		t.Errorf("expected 1 attempt got %d", handler.attempts)
+	}
+}
+
+func TestRetryTransportCustomRetryable(t *testing.T) {
+	handler := &failingServer{failures: 2}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	transport := NewRetryTransport(nil, time.Millisecond, 5)
+	transport.Retryable = func(resp *http.Response, err error) bool {
+		return err != nil
+	}
+	client := &http.Client{Transport: transport}
+
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 got %d", resp.StatusCode)
+	}
+	if handler.attempts != 1 {
+		t.Errorf("expected 1 attempt got %d", handler.attempts)
+	}
+}
+
+func TestRetryTransportConnectionError(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	url := server.URL
+	server.Close()
+
+	client := &http.Client{Transport: NewRetryTransport(nil, time.Millisecond, 3)}
+	if _, err := client.Get(url); err == nil {
+		t.Errorf("expected connection error")
+	}
+}
+
+type closeTrackingBody struct {
+	io.Reader
+	closed bool
+}
+
+func (b *closeTrackingBody) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestRetryTransportClosesBodyWithGetBody(t *testing.T) {
+	handler := &failingServer{failures: 1}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	req, err := http.NewRequest(http.MethodPut, server.URL, strings.NewReader("payload"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	body := &closeTrackingBody{Reader: strings.NewReader("payload")}
+	req.Body = body
+
+	resp, err := NewRetryTransport(nil, time.Millisecond, 3).RoundTrip(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if !body.closed {
+		t.Errorf("expected the original request body to be closed")
+	}
+	if handler.attempts != 2 || handler.bodies[1] != "payload" {
+		t.Errorf("expected 2 attempts with the body replayed got %d %v", handler.attempts, handler.bodies)
+	}
+}
+
+func TestRetryTransportInvalidInterval(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	client := &http.Client{Transport: NewRetryTransport(nil, 0, 3)}
+	if _, err := client.Get(server.URL); !errors.Is(err, ErrInvalidInterval) {
+		t.Errorf("expected ErrInvalidInterval got %v", err)
+	}
+}
+
+func TestLoadStructFromURL(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/config.json", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"name": "json", "count": 1}`))
+	})
+	mux.HandleFunc("/config.yaml", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("name: yaml\ncount: 2\n"))
+	})
+	mux.HandleFunc("/config", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/yaml; charset=utf-8")
+		_, _ = w.Write([]byte("name: content-type\ncount: 3\n"))
+	})
+	mux.HandleFunc("/unknown", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte("name = 'toml'"))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	tests := []struct {
+		path          string
+		expected      testConfig
+		errorContains string
+	}{
+		{path: "/config.json", expected: testConfig{Name: "json", Count: 1}},
+		{path: "/config.yaml", expected: testConfig{Name: "yaml", Count: 2}},
+		{path: "/config?format=ignored", expected: testConfig{Name: "content-type", Count: 3}},
+		{path: "/missing.json", errorContains: "404"},
+		{path: "/unknown", errorContains: "unrecognised content type"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			cfg, err := LoadStructFromURL[testConfig](context.Background(), server.URL+tt.path)
+			if tt.errorContains != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errorContains) {
+					t.Errorf("expected error containing '%s' got '%v'", tt.errorContains, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if *cfg != tt.expected {
+				t.Errorf("expected %v got %v", tt.expected, *cfg)
+			}
+		})
+	}
+}
+
+func TestLoadStructFromURLCanceled(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	_, err := LoadStructFromURL[testConfig](ctx, server.URL+"/config.json")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded got %v", err)
+	}
+}
@@ -0,0 +1,263 @@
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// isJSONLinesFormat reports whether format names JSON Lines, one JSON value per line
+func isJSONLinesFormat(format string) bool {
+	switch strings.ToLower(format) {
+	case "jsonl", "ndjson":
+		return true
+	}
+	return false
+}
+
+func isJSONLinesFilePath(path string) bool {
+	return isJSONLinesFormat(formatFromFilePath(path))
+}
+
+// jsonLinesDecoder decodes JSON Lines. Decoding into a slice appends one element per non-blank line, decoding
+// into anything else expects a single non-blank line.
+type jsonLinesDecoder struct {
+	r io.Reader
+}
+
+func jsonLinesDecoderFunc(r io.Reader) decoder {
+	return &jsonLinesDecoder{r: r}
+}
+
+func (d *jsonLinesDecoder) Decode(v any) error {
+	target := reflect.ValueOf(v)
+	if target.Kind() != reflect.Ptr || target.IsNil() {
+		return fmt.Errorf("expected non-nil pointer, got %T", v)
+	}
+	target = target.Elem()
+
+	isSlice := target.Kind() == reflect.Slice
+	if isSlice {
+		target.Set(reflect.MakeSlice(target.Type(), 0, 0))
+	}
+
+	reader := bufio.NewReader(d.r)
+	decoded := 0
+	for lineNumber := 1; ; lineNumber++ {
+		line, err := reader.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+
+		if trimmed := bytes.TrimSpace(line); len(trimmed) > 0 {
+			if !isSlice && decoded > 0 {
+				return fmt.Errorf("unexpected second record on line %d. expected a slice to decode multiple lines", lineNumber)
+			}
+
+			item := target
+			if isSlice {
+				item = reflect.New(target.Type().Elem()).Elem()
+			}
+			if unmarshalErr := json.Unmarshal(trimmed, item.Addr().Interface()); unmarshalErr != nil {
+				return fmt.Errorf("failed to decode line %d: %w", lineNumber, unmarshalErr)
+			}
+			if isSlice {
+				target.Set(reflect.Append(target, item))
+			}
+			decoded++
+		}
+
+		if errors.Is(err, io.EOF) {
+			if !isSlice && decoded == 0 {
+				return io.EOF
+			}
+			return nil
+		}
+	}
+}
+
+// jsonLinesEncoder encodes JSON Lines, writing each element of a slice or array on its own line and any other
+// value as a single line
+type jsonLinesEncoder struct {
+	encoder *json.Encoder
+}
+
+func jsonLinesEncoderFunc(w io.Writer) encoder {
+	return &jsonLinesEncoder{encoder: json.NewEncoder(w)}
+}
+
+func (e *jsonLinesEncoder) Encode(v any) error {
+	value := reflect.ValueOf(v)
+	for value.Kind() == reflect.Ptr && !value.IsNil() {
+		value = value.Elem()
+	}
+
+	if value.Kind() != reflect.Slice && value.Kind() != reflect.Array {
+		return e.encoder.Encode(v)
+	}
+
+	for i := 0; i < value.Len(); i++ {
+		if err := e.encoder.Encode(value.Index(i).Interface()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func loadStructsFromJSONLinesReader[T any](r io.Reader) ([]*T, error) {
+	items := make([]*T, 0)
+	if err := jsonLinesDecoderFunc(r).Decode(&items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+func saveStructsToJSONLinesWriter[T any](items []*T, w io.Writer) error {
+	return saveStructToWriterWithEncoder(&items, w, jsonLinesEncoderFunc)
+}
+
+// LoadStructsFromJSONLines loads a slice of structs from a JSON Lines (.jsonl/.ndjson) file,
+// decoding one JSON object per line. Blank lines are skipped.
+func LoadStructsFromJSONLines[T any](filePath string) ([]*T, error) {
+	if !isJSONLinesFilePath(filePath) {
+		return nil, fmt.Errorf("unrecognised file type. expected jsonl or ndjson")
+	}
+
+	structFile, err := CleanOpen(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := loadStructsFromJSONLinesReader[T](structFile)
+
+	if err != nil {
+		closeErr := structFile.Close()
+		if closeErr != nil {
+			return nil, fmt.Errorf("%w: %v", err, closeErr)
+		}
+		return nil, err
+	}
+
+	return items, structFile.Close()
+}
+
+// SaveStructsToJSONLines saves a slice of structs to a JSON Lines (.jsonl/.ndjson) file,
+// encoding one JSON object per line.
+func SaveStructsToJSONLines[T any](items []*T, filePath string) error {
+	if !isJSONLinesFilePath(filePath) {
+		return fmt.Errorf("unrecognised file type. expected jsonl or ndjson")
+	}
+
+	structFile, err := CleanCreate(filePath)
+	if err != nil {
+		return err
+	}
+
+	err = saveStructsToJSONLinesWriter(items, structFile)
+
+	if err != nil {
+		closeErr := structFile.Close()
+		if closeErr != nil {
+			return fmt.Errorf("%w: %v", err, closeErr)
+		}
+		return err
+	}
+
+	return structFile.Close()
+}
+
+func streamJSONArrayFromReader[T any](r io.Reader, fn func(*T) error) error {
+	decoder := json.NewDecoder(r)
+
+	token, err := decoder.Token()
+	if err != nil {
+		return fmt.Errorf("failed to read start of array: %w", err)
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '[' {
+		return fmt.Errorf("expected start of array got %v", token)
+	}
+
+	for index := 0; decoder.More(); index++ {
+		var item T
+		if err := decoder.Decode(&item); err != nil {
+			return fmt.Errorf("failed to decode element %d: %w", index, err)
+		}
+		if err := fn(&item); err != nil {
+			return err
+		}
+	}
+
+	if _, err := decoder.Token(); err != nil {
+		return fmt.Errorf("failed to read end of array: %w", err)
+	}
+	return nil
+}
+
+// StreamJSONArray decodes the elements of a JSON array file one at a time, calling fn with each, so the whole
+// array is never held in memory. It stops and returns the error if fn returns one.
+func StreamJSONArray[T any](filePath string, fn func(*T) error) error {
+	structFile, err := CleanOpen(filePath)
+	if err != nil {
+		return err
+	}
+
+	err = streamJSONArrayFromReader(structFile, fn)
+
+	if err != nil {
+		closeErr := structFile.Close()
+		if closeErr != nil {
+			return fmt.Errorf("%w: %v", err, closeErr)
+		}
+		return err
+	}
+
+	return structFile.Close()
+}
+
+// AppendJSONLine marshals v to a single line of JSON and appends it to the file at path, creating the file and
+// its parent directory if they don't exist. Writers are serialised within the process and, where supported, with
+// an advisory lock on the file so that concurrent appends from other processes using AppendJSONLine don't
+// interleave.
+func AppendJSONLine(path string, v any) error {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	expandedPath, err := ExpandPath(path)
+	if err != nil {
+		return err
+	}
+
+	unlock := lockStructFile(expandedPath)
+	defer unlock()
+
+	f, err := CleanOpenAppend(expandedPath, 0600)
+	if err != nil {
+		return err
+	}
+
+	unlockFile, err := lockFile(f)
+	if err == nil {
+		_, err = f.Write(line)
+		if unlockErr := unlockFile(); err == nil {
+			err = unlockErr
+		}
+	}
+
+	if err != nil {
+		closeErr := f.Close()
+		if closeErr != nil {
+			return fmt.Errorf("%w: %v", err, closeErr)
+		}
+		return err
+	}
+
+	return f.Close()
+}
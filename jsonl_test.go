@@ -0,0 +1,280 @@
+package util
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestJSONLinesRoundTrip(t *testing.T) {
+	items := []*testConfig{
+		{Name: "one", Count: 1},
+		{Name: "two", Count: 2},
+		{Name: "three", Count: 3},
+	}
+
+	for _, ext := range []string{".jsonl", ".ndjson"} {
+		t.Run(ext, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "records"+ext)
+			if err := SaveStructsToJSONLines(items, path); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			content, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if lines := strings.Split(strings.TrimSpace(string(content)), "\n"); len(lines) != len(items) {
+				t.Errorf("expected %d lines got %d", len(items), len(lines))
+			}
+
+			loaded, err := LoadStructsFromJSONLines[testConfig](path)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if len(loaded) != len(items) {
+				t.Fatalf("expected %d items got %d", len(items), len(loaded))
+			}
+			for i := range items {
+				if *loaded[i] != *items[i] {
+					t.Errorf("expected %v got %v", *items[i], *loaded[i])
+				}
+			}
+		})
+	}
+}
+
+func TestJSONLinesCodec(t *testing.T) {
+	items := []testConfig{
+		{Name: "one", Count: 1},
+		{Name: "two", Count: 2},
+	}
+
+	dir := t.TempDir()
+	for _, ext := range []string{".jsonl", ".NDJSON"} {
+		t.Run(ext, func(t *testing.T) {
+			path := filepath.Join(dir, "records"+ext)
+			if err := SaveStructToFile(&items, path); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			content, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if expected := "{\"name\":\"one\",\"count\":1}\n{\"name\":\"two\",\"count\":2}\n"; string(content) != expected {
+				t.Errorf("expected '%s' got '%s'", expected, content)
+			}
+
+			loaded, err := LoadStructFromFile[[]testConfig](path)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if len(*loaded) != len(items) || (*loaded)[0] != items[0] || (*loaded)[1] != items[1] {
+				t.Errorf("expected %v got %v", items, *loaded)
+			}
+
+			if _, err := LoadStructFromFile[testConfig](path); err == nil {
+				t.Errorf("expected error loading several records into a single struct")
+			}
+		})
+	}
+
+	singlePath := filepath.Join(dir, "single.jsonl")
+	if err := SaveStructToFile(&testConfig{Name: "single", Count: 1}, singlePath); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	single, err := LoadStructFromFile[testConfig](singlePath)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if single.Name != "single" || single.Count != 1 {
+		t.Errorf("expected {single 1} got %v", *single)
+	}
+}
+
+func TestLoadStructsFromJSONLinesBlankLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "records.jsonl")
+	content := "{\"name\": \"one\", \"count\": 1}\n\n  \n{\"name\": \"two\", \"count\": 2}\n\n\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	loaded, err := LoadStructsFromJSONLines[testConfig](path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(loaded) != 2 {
+		t.Fatalf("expected 2 items got %d", len(loaded))
+	}
+	if loaded[1].Name != "two" {
+		t.Errorf("expected 'two' got '%s'", loaded[1].Name)
+	}
+}
+
+func TestLoadStructsFromJSONLinesInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "records.jsonl")
+	if err := os.WriteFile(path, []byte("{\"name\": \"one\"}\n{\"name\": \n"), 0600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	_, err := LoadStructsFromJSONLines[testConfig](path)
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("expected error naming line 2 got %v", err)
+	}
+
+	if _, err := LoadStructsFromJSONLines[testConfig](filepath.Join(t.TempDir(), "records.json")); err == nil {
+		t.Errorf("expected error for unrecognised extension")
+	}
+}
+
+func TestFilterAndMapLoadedStructs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "records.jsonl")
+	items := []*testConfig{
+		{Name: "one", Count: 1},
+		{Name: "two", Count: 2},
+		{Name: "three", Count: 3},
+		{Name: "four", Count: 4},
+	}
+	if err := SaveStructsToJSONLines(items, path); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	loaded, err := LoadStructsFromJSONLines[testConfig](path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	even := FilterStructs(loaded, func(c *testConfig) bool {
+		return c.Count%2 == 0
+	})
+	if len(even) != 2 || even[0].Name != "two" || even[1].Name != "four" {
+		t.Errorf("expected [two four] got %v", MapStructs(even, func(c *testConfig) string { return c.Name }))
+	}
+
+	names := MapStructs(loaded, func(c *testConfig) string {
+		return strings.ToUpper(c.Name)
+	})
+	expected := []string{"ONE", "TWO", "THREE", "FOUR"}
+	if strings.Join(names, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected %v got %v", expected, names)
+	}
+
+	if none := FilterStructs(loaded, func(*testConfig) bool { return false }); none == nil || len(none) != 0 {
+		t.Errorf("expected empty slice got %v", none)
+	}
+}
+
+func TestStreamJSONArray(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "records.json")
+	content := `[{"name": "one", "count": 1}, {"name": "two", "count": 2}, {"name": "three", "count": 3}]`
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	names := make([]string, 0)
+	err := StreamJSONArray(path, func(item *testConfig) error {
+		names = append(names, item.Name)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if strings.Join(names, ",") != "one,two,three" {
+		t.Errorf("expected 'one,two,three' got '%s'", strings.Join(names, ","))
+	}
+
+	errStop := errors.New("stop")
+	calls := 0
+	err = StreamJSONArray(path, func(item *testConfig) error {
+		calls++
+		if item.Name == "two" {
+			return errStop
+		}
+		return nil
+	})
+	if !errors.Is(err, errStop) {
+		t.Errorf("expected fn error got %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("expected 2 calls got %d", calls)
+	}
+}
+
+func TestStreamJSONArrayInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{name: "not an array", content: `{"name": "one"}`},
+		{name: "bad element", content: `[{"name": "one"}, {"name": 2}]`},
+		{name: "truncated", content: `[{"name": "one"}`},
+		{name: "empty", content: ``},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "records.json")
+			if err := os.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if err := StreamJSONArray(path, func(*testConfig) error { return nil }); err == nil {
+				t.Errorf("expected error")
+			}
+		})
+	}
+}
+
+func TestAppendJSONLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events", "events.jsonl")
+
+	writers := 10
+	perWriter := 50
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				record := &testConfig{Name: strings.Repeat(fmt.Sprintf("writer-%d-", w), 20), Count: i}
+				if err := AppendJSONLine(path, record); err != nil {
+					t.Errorf("unexpected error: %s", err)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	if len(lines) != writers*perWriter {
+		t.Fatalf("expected %d lines got %d", writers*perWriter, len(lines))
+	}
+	for i, line := range lines {
+		var record testConfig
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("line %d is not valid JSON: %s", i+1, err)
+		}
+	}
+
+	loaded, err := LoadStructsFromJSONLines[testConfig](path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(loaded) != writers*perWriter {
+		t.Errorf("expected %d records got %d", writers*perWriter, len(loaded))
+	}
+
+	if err := AppendJSONLine(path, func() {}); err == nil {
+		t.Errorf("expected error for unmarshallable value")
+	}
+}
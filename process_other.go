@@ -0,0 +1,17 @@
+//go:build !unix
+
+package util
+
+import (
+	"os"
+)
+
+// processExists reports whether a process with pid can be found. Signal 0 isn't available so this relies on
+// os.FindProcess, which on Windows may still find a process that has exited while handles to it remain open.
+func processExists(pid int) (bool, error) {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false, nil
+	}
+	return true, p.Release()
+}
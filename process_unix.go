@@ -0,0 +1,30 @@
+//go:build unix
+
+package util
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// processExists reports whether a process with pid exists by sending it signal 0. A process owned by another
+// user (EPERM) exists. Exited but unreaped (zombie) processes are still reported as existing.
+func processExists(pid int) (bool, error) {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false, err
+	}
+	// on Linux FindProcess holds a pidfd until the process is released
+	defer func() { _ = p.Release() }()
+
+	err = p.Signal(syscall.Signal(0))
+	switch {
+	case err == nil, errors.Is(err, syscall.EPERM):
+		return true, nil
+	case errors.Is(err, os.ErrProcessDone), errors.Is(err, syscall.ESRCH):
+		return false, nil
+	default:
+		return false, err
+	}
+}
@@ -0,0 +1,188 @@
+package util
+
+import (
+	"encoding/json"
+	"net/url"
+	"reflect"
+	"strings"
+)
+
+// RedactedPlaceholder replaces the value of sensitive fields in redacted output
+const RedactedPlaceholder = "********"
+
+var maskedStringType = reflect.TypeOf(MaskedString{})
+
+func isMaskTagged(field reflect.StructField) bool {
+	return field.Tag.Get("mask") == "true"
+}
+
+// copyMode controls how copyValue treats sensitive values
+type copyMode int
+
+const (
+	// copyPlain copies values unchanged
+	copyPlain copyMode = iota
+	// copyRedacted redacts fields tagged `mask:"true"` and replaces MaskedStrings with their masked representation
+	copyRedacted
+)
+
+// redactValue returns a copy of v with string fields tagged `mask:"true"` replaced by RedactedPlaceholder.
+// The original value is never modified.
+func redactValue(v reflect.Value) reflect.Value {
+	return copyValue(v, copyRedacted)
+}
+
+// copyValue returns a deep copy of the pointers, structs, slices, arrays and maps in v, treating sensitive
+// values as mode describes.
+func copyValue(v reflect.Value, mode copyMode) reflect.Value {
+	if mode == copyRedacted && v.Type() == maskedStringType {
+		masked := v.Interface().(MaskedString)
+		return reflect.ValueOf(MaskedString{string: masked.String(), Config: MaskedConfig{UnmaskedText: true}})
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v
+		}
+		copied := reflect.New(v.Type().Elem())
+		copied.Elem().Set(copyValue(v.Elem(), mode))
+		return copied
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		copied := reflect.New(v.Type()).Elem()
+		copied.Set(copyValue(v.Elem(), mode))
+		return copied
+	case reflect.Struct:
+		copied := reflect.New(v.Type()).Elem()
+		copied.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			field := copied.Field(i)
+			if !field.CanSet() {
+				continue
+			}
+			if mode == copyRedacted && isMaskTagged(v.Type().Field(i)) {
+				redactField(field)
+				continue
+			}
+			field.Set(copyValue(field, mode))
+		}
+		return copied
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		copied := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			copied.Index(i).Set(copyValue(v.Index(i), mode))
+		}
+		return copied
+	case reflect.Array:
+		copied := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.Len(); i++ {
+			copied.Index(i).Set(copyValue(v.Index(i), mode))
+		}
+		return copied
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		copied := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			copied.SetMapIndex(iter.Key(), copyValue(iter.Value(), mode))
+		}
+		return copied
+	default:
+		return v
+	}
+}
+
+// redactField replaces a tagged string (or non-nil pointer to string) or MaskedString field with RedactedPlaceholder
+func redactField(field reflect.Value) {
+	switch {
+	case field.Type() == maskedStringType:
+		field.Set(reflect.ValueOf(MaskedString{string: RedactedPlaceholder, Config: MaskedConfig{UnmaskedText: true}}))
+	case field.Kind() == reflect.String:
+		field.SetString(RedactedPlaceholder)
+	case field.Kind() == reflect.Ptr && !field.IsNil() && field.Elem().Kind() == reflect.String:
+		placeholder := reflect.New(field.Type().Elem())
+		placeholder.Elem().SetString(RedactedPlaceholder)
+		field.Set(placeholder)
+	}
+}
+
+// redacted returns a redacted copy of v suitable for marshalling
+func redacted(v any) any {
+	if v == nil {
+		return v
+	}
+	return redactValue(reflect.ValueOf(v)).Interface()
+}
+
+// MarshalRedacted marshals v to JSON with any string fields tagged `mask:"true"` replaced by RedactedPlaceholder.
+// MaskedString values are always marshalled masked, even though MarshalJSON emits their cleartext.
+// Nested structs, pointers, slices and maps are walked, untagged fields are marshalled as normal.
+func MarshalRedacted(v any) ([]byte, error) {
+	return json.Marshal(redacted(v))
+}
+
+// ToRedactedJSON returns v as indented JSON, redacted as MarshalRedacted does, for safely logging whole configs.
+func ToRedactedJSON(v any) (string, error) {
+	b, err := json.MarshalIndent(redacted(v), "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// RedactURL returns the URL as a string with any userinfo replaced, "***:***@" when a password is present
+// and "***@" for a username alone, so it is safe to log. The URL itself is not modified.
+// URLs without a scheme have their userinfo removed.
+func RedactURL(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	if u.User == nil {
+		return u.String()
+	}
+
+	userInfo := "***@"
+	if _, hasPassword := u.User.Password(); hasPassword {
+		userInfo = "***:***@"
+	}
+
+	redacted := *u
+	redacted.User = nil
+	s := redacted.String()
+
+	// url.UserPassword would percent-encode the placeholder, so splice it in after the scheme instead
+	prefix := redacted.Scheme + "://"
+	if !strings.HasPrefix(s, prefix) {
+		return s
+	}
+	return prefix + userInfo + strings.TrimPrefix(s, prefix)
+}
+
+// MaskSensitiveMap returns a copy of m with the value of any key containing one of sensitiveKeys, compared
+// case-insensitively, replaced by RedactedPlaceholder, e.g. "password" matches "DB_PASSWORD". m is not modified.
+func MaskSensitiveMap(m map[string]string, sensitiveKeys []string) map[string]string {
+	if m == nil {
+		return nil
+	}
+
+	masked := make(map[string]string, len(m))
+	for key, value := range m {
+		masked[key] = value
+		lowerKey := strings.ToLower(key)
+		for _, sensitiveKey := range sensitiveKeys {
+			if sensitiveKey != "" && strings.Contains(lowerKey, strings.ToLower(sensitiveKey)) {
+				masked[key] = RedactedPlaceholder
+				break
+			}
+		}
+	}
+	return masked
+}
@@ -2,17 +2,28 @@ package util
 
 import (
 	"bytes"
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
+	"io"
+	"math"
 	"math/rand"
+	"os"
 	"reflect"
 	"strings"
 	"text/template"
+	"unicode"
 )
 
 // ExpandStringTemplate expands a string template with data.
 func ExpandStringTemplate(templateString string, data any) (string, error) {
-	tmpl, err := template.New("tmpl").Parse(templateString)
+	return ExpandStringTemplateDelims(templateString, data, "", "")
+}
+
+// ExpandStringTemplateDelims expands a string template with data using custom left and right action delimiters,
+// e.g. "[[" and "]]". Empty delimiters default to "{{" and "}}".
+func ExpandStringTemplateDelims(templateString string, data any, left, right string) (string, error) {
+	tmpl, err := template.New("tmpl").Delims(left, right).Parse(templateString)
 	if err != nil {
 		return "", err
 	}
@@ -24,6 +35,48 @@ func ExpandStringTemplate(templateString string, data any) (string, error) {
 	return buf.String(), nil
 }
 
+// templateDataWithEnv returns the exported fields of a struct, including those promoted from embedded structs, or
+// the entries of a string keyed map, in data as a map with the environment added under "Env" and data itself
+// under "Data", unless data already has a field or key of that name.
+func templateDataWithEnv(data any, env map[string]string) map[string]any {
+	merged := map[string]any{"Env": env, "Data": data}
+
+	v := reflect.ValueOf(data)
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return merged
+		}
+		v = v.Elem()
+	}
+
+	switch {
+	case v.Kind() == reflect.Struct:
+		for _, field := range reflect.VisibleFields(v.Type()) {
+			if !field.IsExported() {
+				continue
+			}
+			// a nil embedded pointer leaves its promoted fields unset, as a template would fail to reach them
+			if fieldValue, err := v.FieldByIndexErr(field.Index); err == nil {
+				merged[field.Name] = fieldValue.Interface()
+			}
+		}
+	case v.Kind() == reflect.Map && v.Type().Key().Kind() == reflect.String:
+		iter := v.MapRange()
+		for iter.Next() {
+			merged[iter.Key().String()] = iter.Value().Interface()
+		}
+	}
+	return merged
+}
+
+// ExpandStringTemplateWithEnv expands a string template like ExpandStringTemplate with the process environment
+// available as .Env, e.g. {{ .Env.HOME }}. The exported fields of a struct, including promoted fields, or the
+// entries of a map with string keys, in data are available as usual, e.g. {{ .Name }}. Methods aren't, so data
+// itself is also available as .Data, e.g. {{ .Data.Host }}. An Env or Data field or key in data takes precedence.
+func ExpandStringTemplateWithEnv(templateString string, data any) (string, error) {
+	return ExpandStringTemplate(templateString, templateDataWithEnv(data, EnvironMap()))
+}
+
 // SensitiveString Not 'secure' still uses a string as a base type
 // however does protect against accidental exposure in logs
 type MaskedString struct {
@@ -31,12 +84,60 @@ type MaskedString struct {
 	Config MaskedConfig
 }
 
+// MaskedStringDecodeHook is a mapstructure (e.g. Viper) decode hook that converts strings to MaskedString,
+// including the elements of []MaskedString and the values of maps of MaskedString. Slices and maps containing
+// anything other than strings are left for the decoder to handle.
 func MaskedStringDecodeHook(from, to reflect.Type, data interface{}) (interface{}, error) {
-	if from.Kind() != reflect.String || to != reflect.TypeOf(MaskedString{}) {
+	switch {
+	case from.Kind() == reflect.String && to == maskedStringType:
+		return NewMaskedString(data.(string)), nil
+	case (from.Kind() == reflect.Slice || from.Kind() == reflect.Array) && to.Kind() == reflect.Slice && to.Elem() == maskedStringType:
+		return maskedStringSlice(reflect.ValueOf(data), data), nil
+	case from.Kind() == reflect.Map && to.Kind() == reflect.Map && to.Elem() == maskedStringType:
+		return maskedStringMap(reflect.ValueOf(data), data), nil
+	default:
 		return data, nil
 	}
+}
+
+// maskedStringElement returns the MaskedString for a string, or string in an interface, value
+func maskedStringElement(v reflect.Value) (MaskedString, bool) {
+	if v.Kind() == reflect.Interface && !v.IsNil() {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.String {
+		return MaskedString{}, false
+	}
+	return *NewMaskedString(v.String()), true
+}
 
-	return NewMaskedString(data.(string)), nil
+// maskedStringSlice converts the string elements of v to a []MaskedString, returning data unchanged if any
+// element isn't a string
+func maskedStringSlice(v reflect.Value, data interface{}) interface{} {
+	converted := make([]MaskedString, 0, v.Len())
+	for i := 0; i < v.Len(); i++ {
+		m, ok := maskedStringElement(v.Index(i))
+		if !ok {
+			return data
+		}
+		converted = append(converted, m)
+	}
+	return converted
+}
+
+// maskedStringMap converts the string values of v to MaskedString, keeping the keys, returning data unchanged
+// if any value isn't a string
+func maskedStringMap(v reflect.Value, data interface{}) interface{} {
+	converted := reflect.MakeMapWithSize(reflect.MapOf(v.Type().Key(), maskedStringType), v.Len())
+	iter := v.MapRange()
+	for iter.Next() {
+		m, ok := maskedStringElement(iter.Value())
+		if !ok {
+			return data
+		}
+		converted.SetMapIndex(iter.Key(), reflect.ValueOf(m))
+	}
+	return converted.Interface()
 }
 
 // type U struct {
@@ -55,15 +156,49 @@ type MaskedConfig struct {
 	MinMask          uint
 	ObfuscateLength  bool
 	ObfuscatedLength uint
+	// MaskRatio (0..1) reveals round(len*MaskRatio) characters at the front of the string.
+	// It is only used when PrefixCount is 0, an explicit PrefixCount takes precedence.
+	MaskRatio float64
+	// PreserveNonAlphanumeric masks only letters and digits, leaving separators such as dashes and spaces
+	// visible, e.g. "1234-5678" is masked as "****-****". ObfuscateLength is ignored when set.
+	PreserveNonAlphanumeric bool
+	// UnmaskedText makes MarshalText emit the cleartext rather than the masked representation. MarshalJSON and
+	// MarshalYAML always emit the cleartext.
+	UnmaskedText bool
+	// MaskPlaceholder, when set, is emitted by String() in place of the whole masked value, e.g. "[REDACTED]",
+	// regardless of the string's length and the other options.
+	MaskPlaceholder string
+}
+
+// ratioPrefixCount returns the prefix count derived from MaskRatio for a string of length l
+func (c MaskedConfig) ratioPrefixCount(l uint) uint {
+	ratio := c.MaskRatio
+	if ratio <= 0 {
+		return 0
+	}
+	if ratio > 1 {
+		ratio = 1
+	}
+	return uint(math.Round(float64(l) * ratio))
 }
 
 func (s *MaskedString) String() string {
-	l := uint(len(s.string))
-	if s.Config.ObfuscateLength {
+	if s.Config.MaskPlaceholder != "" {
+		return s.Config.MaskPlaceholder
+	}
+
+	runes := []rune(s.string)
+	realLength := uint(len(runes))
+
+	l := realLength
+	if s.Config.ObfuscateLength && !s.Config.PreserveNonAlphanumeric {
 		l = s.Config.ObfuscatedLength
 	}
 
 	prefixCount := s.Config.PrefixCount
+	if prefixCount == 0 {
+		prefixCount = s.Config.ratioPrefixCount(realLength)
+	}
 	if prefixCount > l {
 		prefixCount = 0
 	}
@@ -75,30 +210,18 @@ func (s *MaskedString) String() string {
 
 	unmaskedCharCount := prefixCount + suffixCount
 
-	charsToMask := l - unmaskedCharCount
-
-	minMask := s.Config.MinMask
-
-	if minMask != 0 && minMask > charsToMask {
+	// prefix and suffix are drawn from the real string so must not overlap within it,
+	// and at least one mask character must remain in the (possibly obfuscated) output
+	if unmaskedCharCount >= realLength || unmaskedCharCount >= l {
 		prefixCount = 0
 		suffixCount = 0
-	}
-
-	if unmaskedCharCount >= uint(len(s.string)) {
+	} else if minMask := s.Config.MinMask; minMask != 0 && minMask > l-unmaskedCharCount {
 		prefixCount = 0
 		suffixCount = 0
 	}
 
-	prefix := ""
-	if prefixCount > 0 {
-		prefix = s.string[:prefixCount]
-	}
-
-	suffix := ""
-	if suffixCount > 0 {
-		leadingChars := len(s.string) - int(suffixCount)
-		suffix = s.string[leadingChars:]
-	}
+	prefix := string(runes[:prefixCount])
+	suffix := string(runes[realLength-suffixCount:])
 
 	paddingCount := l - (prefixCount + suffixCount)
 
@@ -108,14 +231,65 @@ func (s *MaskedString) String() string {
 	}
 
 	mask := strings.Repeat(maskChar, int(paddingCount))
+	if s.Config.PreserveNonAlphanumeric {
+		mask = maskAlphanumeric(runes[prefixCount:realLength-suffixCount], maskChar)
+	}
 
 	return fmt.Sprintf("%s%s%s", prefix, mask, suffix)
 }
 
+// maskAlphanumeric replaces letters and digits with maskChar, leaving all other characters intact
+func maskAlphanumeric(runes []rune, maskChar string) string {
+	var b strings.Builder
+	for _, r := range runes {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteString(maskChar)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// MaskedString returns the underlying unmasked string.
+//
+// Deprecated: use UnmaskedString, which is named for what it returns.
 func (s *MaskedString) MaskedString() string {
 	return s.string
 }
 
+// UnmaskedString returns the underlying unmasked string.
+func (s *MaskedString) UnmaskedString() string {
+	return s.string
+}
+
+// WithUnmasked calls fn with the underlying unmasked string, scoping access to the cleartext to fn.
+func (s *MaskedString) WithUnmasked(fn func(string)) {
+	fn(s.string)
+}
+
+// WithUnmaskedErr calls fn with the underlying unmasked string, returning the error from fn.
+func (s *MaskedString) WithUnmaskedErr(fn func(string) error) error {
+	return fn(s.string)
+}
+
+// Clone returns a new MaskedString with the same underlying string and config.
+func (s *MaskedString) Clone() *MaskedString {
+	return &MaskedString{
+		string: s.string,
+		Config: s.Config,
+	}
+}
+
+// EqualValue reports whether a and b hold the same underlying string, ignoring their configs. The comparison is
+// constant-time with respect to the content. Two nil MaskedStrings are equal.
+func EqualValue(a, b *MaskedString) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return subtle.ConstantTimeCompare([]byte(a.string), []byte(b.string)) == 1
+}
+
 func (s *MaskedString) UnmarshalJSON(data []byte) error {
 	var str string
 	if err := json.Unmarshal(data, &str); err != nil {
@@ -126,10 +300,40 @@ func (s *MaskedString) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// MarshalJSON implements json.Marshaler, emitting the cleartext so that saved files round trip.
+// Use MarshalRedacted to marshal with MaskedStrings masked.
+func (s MaskedString) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.string)
+}
+
+// MarshalYAML implements yaml.Marshaler, emitting the cleartext so that saved files round trip.
+func (s MaskedString) MarshalYAML() (interface{}, error) {
+	return s.string, nil
+}
+
+// MarshalText implements encoding.TextMarshaler. Unlike MarshalJSON and MarshalYAML, to avoid accidental
+// exposure through other encoders, such as URL query encoders, it emits the masked representation unless
+// Config.UnmaskedText is set.
+func (s MaskedString) MarshalText() ([]byte, error) {
+	if s.Config.UnmaskedText {
+		return []byte(s.string), nil
+	}
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler, setting the underlying string to the text.
+func (s *MaskedString) UnmarshalText(text []byte) error {
+	s.string = string(text)
+	return nil
+}
+
 // NewMaskedString creates a new masked string
 func NewMaskedString(s string) *MaskedString {
 	baseLength := int(1.5 * float32(len(s)))
-	randomLength := rand.Intn(baseLength)
+	randomLength := 0
+	if baseLength > 0 {
+		randomLength = rand.Intn(baseLength)
+	}
 
 	m := &MaskedString{
 		string: s,
@@ -138,3 +342,87 @@ func NewMaskedString(s string) *MaskedString {
 
 	return m
 }
+
+func newMaskedStringFromEnv(lookup envLookup, key string) (*MaskedString, error) {
+	value, ok := lookup(key)
+	if !ok {
+		return nil, fmt.Errorf("%w: %v", ErrEnvNotSet, key)
+	}
+	return NewMaskedString(value), nil
+}
+
+// NewMaskedStringFromEnv creates a new masked string from an environment variable.
+// It returns an error if the environment variable is not set.
+func NewMaskedStringFromEnv(key string) (*MaskedString, error) {
+	return newMaskedStringFromEnv(os.LookupEnv, key)
+}
+
+// NewMaskedStringFromEnvWithDefault creates a new masked string from an environment variable
+// using defaultValue if the environment variable is not set.
+func NewMaskedStringFromEnvWithDefault(key, defaultValue string) *MaskedString {
+	return NewMaskedString(lookupEnvWithDefault(os.LookupEnv, key, defaultValue))
+}
+
+// MaskPreset is a named MaskedConfig for common kinds of secret
+type MaskPreset int
+
+const (
+	// MaskPresetPassword fully masks the value and hides its length, always emitting 8 mask characters
+	MaskPresetPassword MaskPreset = iota
+	// MaskPresetToken reveals the first 4 and last 4 characters, fully masking tokens with fewer than 4 hidden characters
+	MaskPresetToken
+	// MaskPresetEmail masks the local part of an email address, revealing the "@domain", or fully masks values without an @
+	MaskPresetEmail
+)
+
+// maskPresetConfig returns the MaskedConfig for a preset applied to s
+func maskPresetConfig(s string, preset MaskPreset) MaskedConfig {
+	switch preset {
+	case MaskPresetToken:
+		return MaskedConfig{
+			PrefixCount: 4,
+			SuffixCount: 4,
+			MinMask:     4,
+		}
+	case MaskPresetEmail:
+		at := strings.LastIndex(s, "@")
+		if at == -1 {
+			return MaskedConfig{}
+		}
+		return MaskedConfig{
+			SuffixCount: uint(len([]rune(s[at:]))),
+		}
+	default:
+		return MaskedConfig{
+			ObfuscateLength:  true,
+			ObfuscatedLength: 8,
+		}
+	}
+}
+
+// NewMaskedStringPreset creates a new masked string configured with a preset
+func NewMaskedStringPreset(s string, preset MaskPreset) *MaskedString {
+	m := NewMaskedString(s)
+	m.Config = maskPresetConfig(s, preset)
+	return m
+}
+
+// LoadMaskedStringFromFile reads the contents of a file, such as a PEM-encoded private key, into a masked
+// string using MaskPresetPassword so that neither the contents nor their length are exposed when printed.
+func LoadMaskedStringFromFile(path string) (*MaskedString, error) {
+	f, err := CleanOpen(path)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := io.ReadAll(f)
+	if err != nil {
+		closeErr := f.Close()
+		if closeErr != nil {
+			return nil, fmt.Errorf("%w: %v", err, closeErr)
+		}
+		return nil, err
+	}
+
+	return NewMaskedStringPreset(string(content), MaskPresetPassword), f.Close()
+}
@@ -0,0 +1,31 @@
+// Package utiltest provides test helpers for code that uses util.
+package utiltest
+
+import (
+	"os"
+	"testing"
+
+	"github.com/dioad/util"
+)
+
+// SnapshotEnv captures the current environment and restores it when tb and its subtests complete, unsetting
+// variables that were added and resetting any that were changed or removed since. It is intended for code that
+// changes the environment directly rather than through tb.Setenv, and like tb.Setenv must not be used in
+// parallel tests.
+func SnapshotEnv(tb testing.TB) {
+	tb.Helper()
+
+	snapshot := util.EnvironMap()
+	tb.Cleanup(func() {
+		for key := range util.EnvironMap() {
+			if _, ok := snapshot[key]; !ok {
+				_ = os.Unsetenv(key)
+			}
+		}
+		for key, value := range snapshot {
+			if current, ok := os.LookupEnv(key); !ok || current != value {
+				_ = os.Setenv(key, value)
+			}
+		}
+	})
+}
@@ -0,0 +1,38 @@
+package utiltest
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/dioad/util"
+)
+
+func TestSnapshotEnv(t *testing.T) {
+	t.Setenv("SNAPSHOT_EXISTING", "original")
+	t.Setenv("SNAPSHOT_REMOVED", "present")
+
+	before := util.EnvironMap()
+
+	t.Run("changes", func(t *testing.T) {
+		SnapshotEnv(t)
+
+		_ = os.Setenv("SNAPSHOT_EXISTING", "changed")
+		_ = os.Setenv("SNAPSHOT_ADDED", "added")
+		_ = os.Unsetenv("SNAPSHOT_REMOVED")
+	})
+
+	after := util.EnvironMap()
+	if !reflect.DeepEqual(before, after) {
+		t.Errorf("expected environment to be restored")
+	}
+	if _, ok := os.LookupEnv("SNAPSHOT_ADDED"); ok {
+		t.Errorf("expected SNAPSHOT_ADDED to be unset")
+	}
+	if value := os.Getenv("SNAPSHOT_EXISTING"); value != "original" {
+		t.Errorf("expected 'original' got '%s'", value)
+	}
+	if value := os.Getenv("SNAPSHOT_REMOVED"); value != "present" {
+		t.Errorf("expected 'present' got '%s'", value)
+	}
+}
@@ -1,20 +1,102 @@
 package util
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"math"
+	"math/rand"
 	"time"
+
+	"golang.org/x/sync/errgroup"
 )
 
-// WaitFor waits for a function to return true, it will check every interval seconds up until max seconds.
-func WaitFor(interval time.Duration, maxTries uint, op func() bool) error {
+// clock abstracts the passing of time so waits can be tested without real delays
+type clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// ErrInvalidInterval is returned by waits given an interval that isn't positive when there's more than one try,
+// which would otherwise busy-loop
+var ErrInvalidInterval = errors.New("invalid wait interval")
+
+// validateInterval returns ErrInvalidInterval if interval isn't positive when maxTries allows more than one try
+func validateInterval(interval time.Duration, maxTries uint) error {
+	if interval <= 0 && maxTries > 1 {
+		return fmt.Errorf("%w: %v. expected a positive duration for %d tries", ErrInvalidInterval, interval, maxTries)
+	}
+	return nil
+}
+
+// waitUntil calls op up to maxTries times, waiting interval between attempts, until op returns true.
+// It returns ctx.Err() if ctx is done before the condition is met and ErrInvalidInterval if interval isn't
+// positive when maxTries is more than 1.
+func waitUntil(ctx context.Context, c clock, interval time.Duration, maxTries uint, op func() bool) error {
+	if err := validateInterval(interval, maxTries); err != nil {
+		return err
+	}
+	return waitUntilIntervals(ctx, c, maxTries, func() (bool, time.Duration) {
+		return op(), interval
+	})
+}
+
+// waitForReturn calls op up to maxTries times, waiting interval between attempts, until op returns a nil error.
+// If maxTries is 0 op is called once. It returns ctx.Err() if ctx is done before op succeeds and
+// ErrInvalidInterval if interval isn't positive when maxTries is more than 1.
+func waitForReturn[T any](ctx context.Context, c clock, interval time.Duration, maxTries uint, op func() (*T, error)) (*T, error) {
 	var i uint
+
+	if maxTries == 0 {
+		maxTries = 1
+	}
+	if err := validateInterval(interval, maxTries); err != nil {
+		return nil, err
+	}
+
 	for i = 0; i < maxTries; i++ {
-		if op() {
-			return nil
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		resp, err := op()
+		if err == nil {
+			return resp, nil
+		}
+		if i < maxTries-1 {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-c.After(interval):
+			}
 		}
-		time.Sleep(interval)
 	}
-	return fmt.Errorf("condition not met")
+	return nil, fmt.Errorf("condition not met")
+}
+
+// WaitFor waits for a function to return true, it will check every interval seconds up until max seconds.
+func WaitFor(interval time.Duration, maxTries uint, op func() bool) error {
+	return waitUntil(context.Background(), realClock{}, interval, maxTries, op)
+}
+
+// WaitForChan runs WaitFor in a goroutine and delivers its result (nil on success) on the returned channel
+// exactly once before closing it. The wait stops early with ctx.Err() if ctx is done.
+func WaitForChan(ctx context.Context, interval time.Duration, maxTries uint, op func() bool) <-chan error {
+	result := make(chan error, 1)
+	go func() {
+		defer close(result)
+		result <- waitUntil(ctx, realClock{}, interval, maxTries, op)
+	}()
+	return result
 }
 
 // WaitForNilError waits for a function to return a nil error, it will check every interval seconds up until max seconds.
@@ -28,18 +110,335 @@ func WaitForNilError(interval time.Duration, maxTries uint, op func() error) err
 // The function returns the value and error returned by the function.
 // If maxTries is 0, it will only try once (it will set maxTries internally to 1).
 func WaitForReturn[T any](interval time.Duration, maxTries uint, op func() (*T, error)) (*T, error) {
+	return waitForReturn(context.Background(), realClock{}, interval, maxTries, op)
+}
+
+// waitForStable calls op up to maxTries times until it returns true requiredConsecutive times in a row,
+// resetting the count whenever op returns false.
+func waitForStable(ctx context.Context, c clock, interval time.Duration, maxTries uint, requiredConsecutive uint, op func() bool) error {
+	if requiredConsecutive == 0 {
+		requiredConsecutive = 1
+	}
+
+	var consecutive uint
+	return waitUntil(ctx, c, interval, maxTries, func() bool {
+		if op() {
+			consecutive++
+		} else {
+			consecutive = 0
+		}
+		return consecutive >= requiredConsecutive
+	})
+}
+
+// WaitForStable waits for op to return true requiredConsecutive times in a row, checking every interval
+// up to maxTries times in total. Any false result resets the count.
+func WaitForStable(ctx context.Context, interval time.Duration, maxTries uint, requiredConsecutive uint, op func() bool) error {
+	return waitForStable(ctx, realClock{}, interval, maxTries, requiredConsecutive, op)
+}
+
+// WaitForGroup runs a named wait for each op concurrently, checking every interval up to maxTries times.
+// The waits share a context derived from ctx, so the first failure cancels the rest.
+// It returns the first failure annotated with its name, or nil when all succeed.
+func WaitForGroup(ctx context.Context, interval time.Duration, maxTries uint, ops map[string]func() bool) error {
+	g, groupCtx := errgroup.WithContext(ctx)
+	for name, op := range ops {
+		g.Go(func() error {
+			err := waitUntil(groupCtx, realClock{}, interval, maxTries, op)
+			if err != nil {
+				return fmt.Errorf("%v: %w", name, err)
+			}
+			return nil
+		})
+	}
+	return g.Wait()
+}
+
+func waitForReturnVerbose[T any](ctx context.Context, c clock, interval time.Duration, maxTries uint, op func() (*T, error)) (*T, []error, error) {
+	attemptErrors := make([]error, 0)
+	resp, err := waitForReturn(ctx, c, interval, maxTries, func() (*T, error) {
+		resp, err := op()
+		if err != nil {
+			attemptErrors = append(attemptErrors, err)
+		}
+		return resp, err
+	})
+	return resp, attemptErrors, err
+}
+
+// WaitForReturnVerbose behaves like WaitForReturn but also returns the error from every failed attempt,
+// in order, including those that preceded a successful attempt.
+func WaitForReturnVerbose[T any](ctx context.Context, interval time.Duration, maxTries uint, op func() (*T, error)) (*T, []error, error) {
+	return waitForReturnVerbose(ctx, realClock{}, interval, maxTries, op)
+}
+
+// waitForNilErrorFunc calls op until it returns a nil error, stopping early with the error returned by op
+// when retryable reports that it is not worth retrying. On exhaustion the last error is wrapped.
+func waitForNilErrorFunc(ctx context.Context, c clock, interval time.Duration, maxTries uint, op func() error, retryable func(error) bool) error {
+	var lastErr error
+	var abortErr error
+	err := waitUntil(ctx, c, interval, maxTries, func() bool {
+		lastErr = op()
+		if lastErr != nil && !retryable(lastErr) {
+			abortErr = lastErr
+			return true
+		}
+		return lastErr == nil
+	})
+	if abortErr != nil {
+		return abortErr
+	}
+	if err != nil && lastErr != nil {
+		return fmt.Errorf("%w: %w", err, lastErr)
+	}
+	return err
+}
+
+// WaitForNilErrorFunc waits for op to return a nil error, checking every interval up to maxTries times.
+// Errors for which retryable returns false stop the wait immediately and are returned as-is.
+func WaitForNilErrorFunc(ctx context.Context, interval time.Duration, maxTries uint, op func() error, retryable func(error) bool) error {
+	return waitForNilErrorFunc(ctx, realClock{}, interval, maxTries, op, retryable)
+}
+
+// maxBackoffInterval caps backoff when no max is given, so growth can't overflow a time.Duration once jitter is
+// added
+const maxBackoffInterval = time.Duration(math.MaxInt64 / 2)
+
+// backoffInterval returns the interval before retry attempt (0-based), growing from initial by factor each
+// attempt up to max, or maxBackoffInterval when max is 0, with equal jitter applied: the result is uniformly
+// distributed in [interval/2, interval].
+func backoffInterval(initial, max time.Duration, factor float64, attempt uint) time.Duration {
+	if factor < 1 {
+		factor = 1
+	}
+	if max <= 0 || max > maxBackoffInterval {
+		max = maxBackoffInterval
+	}
+
+	interval := float64(initial) * math.Pow(factor, float64(attempt))
+	if interval > float64(max) {
+		interval = float64(max)
+	}
+
+	half := time.Duration(interval / 2)
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+func waitForReturnBackoff[T any](ctx context.Context, c clock, initial, max time.Duration, factor float64, maxTries uint, op func() (*T, error)) (*T, error) {
 	var i uint
 
 	if maxTries == 0 {
 		maxTries = 1
 	}
+	if err := validateInterval(initial, maxTries); err != nil {
+		return nil, err
+	}
 
+	var lastErr error
 	for i = 0; i < maxTries; i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		resp, err := op()
 		if err == nil {
 			return resp, nil
 		}
-		time.Sleep(interval)
+		lastErr = err
+		if i < maxTries-1 {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-c.After(backoffInterval(initial, max, factor, i)):
+			}
+		}
 	}
-	return nil, fmt.Errorf("condition not met")
+	return nil, fmt.Errorf("condition not met: %w", lastErr)
+}
+
+// WaitForReturnBackoff behaves like WaitForReturn but waits with jittered exponential backoff between attempts,
+// starting at initial and multiplying by factor each attempt up to max (0 for no limit). Each wait is randomised
+// to between half and all of the backoff interval to spread out retries from concurrent callers.
+// On exhaustion the error wraps the last error returned by op.
+func WaitForReturnBackoff[T any](ctx context.Context, initial, max time.Duration, factor float64, maxTries uint, op func() (*T, error)) (*T, error) {
+	return waitForReturnBackoff(ctx, realClock{}, initial, max, factor, maxTries, op)
+}
+
+func waitForProcessExit(ctx context.Context, c clock, exists func(int) (bool, error), interval time.Duration, maxTries uint, pid int) error {
+	if pid <= 0 {
+		return fmt.Errorf("invalid pid %d", pid)
+	}
+
+	var existsErr error
+	err := waitUntil(ctx, c, interval, maxTries, func() bool {
+		var running bool
+		running, existsErr = exists(pid)
+		return existsErr != nil || !running
+	})
+	if existsErr != nil {
+		return existsErr
+	}
+	if err != nil && ctx.Err() == nil {
+		return fmt.Errorf("process %d still running: %w", pid, err)
+	}
+	return err
+}
+
+// WaitForProcessExit waits for the process with pid to exit, checking every interval up to maxTries times.
+// On Unix existence is checked by sending signal 0, so an exited child that hasn't been reaped by its parent
+// (a zombie) is still considered running. On other platforms os.FindProcess is used, which on Windows may find
+// an exited process while handles to it remain open.
+func WaitForProcessExit(ctx context.Context, interval time.Duration, maxTries uint, pid int) error {
+	return waitForProcessExit(ctx, realClock{}, processExists, interval, maxTries, pid)
+}
+
+func waitForProgress(ctx context.Context, c clock, interval time.Duration, maxTries uint, op func() (bool, float64), onProgress func(float64)) error {
+	return waitUntil(ctx, c, interval, maxTries, func() bool {
+		done, progress := op()
+		if onProgress != nil {
+			onProgress(math.Max(0, math.Min(1, progress)))
+		}
+		return done
+	})
+}
+
+// WaitForProgress waits for op to report done, checking every interval up to maxTries times. After each attempt
+// onProgress, if not nil, is called with the progress fraction reported by op, clamped to the range 0 to 1.
+func WaitForProgress(ctx context.Context, interval time.Duration, maxTries uint, op func() (done bool, progress float64), onProgress func(float64)) error {
+	return waitForProgress(ctx, realClock{}, interval, maxTries, op, onProgress)
+}
+
+func waitForReturnStopOn[T any](ctx context.Context, c clock, interval time.Duration, maxTries uint, op func() (*T, error), stop func(error) bool) (*T, error) {
+	var stopErr error
+	resp, err := waitForReturn(ctx, c, interval, maxTries, func() (*T, error) {
+		resp, err := op()
+		if err != nil && stop(err) {
+			// end the wait early, the terminal error is returned below
+			stopErr = err
+			return nil, nil
+		}
+		return resp, err
+	})
+	if stopErr != nil {
+		return nil, stopErr
+	}
+	return resp, err
+}
+
+// WaitForReturnStopOn behaves like WaitForReturn but returns immediately with the error from op when stop
+// reports it as terminal, e.g. a not found response for something that will never appear.
+func WaitForReturnStopOn[T any](ctx context.Context, interval time.Duration, maxTries uint, op func() (*T, error), stop func(error) bool) (*T, error) {
+	return waitForReturnStopOn(ctx, realClock{}, interval, maxTries, op, stop)
+}
+
+func waitForCapped(ctx context.Context, c clock, interval time.Duration, maxTries uint, maxTotal time.Duration, op func() bool) error {
+	if maxTotal <= 0 {
+		return waitUntil(ctx, c, interval, maxTries, op)
+	}
+	if err := validateInterval(interval, maxTries); err != nil {
+		return err
+	}
+
+	deadline := c.Now().Add(maxTotal)
+
+	var i uint
+	for i = 0; i < maxTries; i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if op() {
+			return nil
+		}
+		if i == maxTries-1 {
+			break
+		}
+
+		remaining := deadline.Sub(c.Now())
+		if remaining <= 0 {
+			return fmt.Errorf("condition not met within %v", maxTotal)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-c.After(min(interval, remaining)):
+		}
+	}
+	return fmt.Errorf("condition not met")
+}
+
+// WaitForCapped waits for op to return true, checking every interval until maxTries attempts have been made or
+// maxTotal has elapsed, whichever comes first. The final wait is shortened so that op is checked once more at
+// the deadline. A maxTotal of 0 applies no cap.
+func WaitForCapped(ctx context.Context, interval time.Duration, maxTries uint, maxTotal time.Duration, op func() bool) error {
+	return waitForCapped(ctx, realClock{}, interval, maxTries, maxTotal, op)
+}
+
+func waitForProgressBackoff(ctx context.Context, c clock, initial, max time.Duration, factor float64, maxTries uint, op func() (bool, bool)) error {
+	if err := validateInterval(initial, maxTries); err != nil {
+		return err
+	}
+
+	var step uint
+	return waitUntilIntervals(ctx, c, maxTries, func() (bool, time.Duration) {
+		done, progressed := op()
+		if progressed {
+			step = 0
+		}
+		interval := backoffInterval(initial, max, factor, step)
+		step++
+		return done, interval
+	})
+}
+
+// waitUntilIntervals calls op up to maxTries times until it returns true, waiting the interval returned by op
+// before the next attempt. It returns ctx.Err() if ctx is done before the condition is met.
+func waitUntilIntervals(ctx context.Context, c clock, maxTries uint, op func() (bool, time.Duration)) error {
+	var i uint
+	for i = 0; i < maxTries; i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		done, interval := op()
+		if done {
+			return nil
+		}
+		if i < maxTries-1 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-c.After(interval):
+			}
+		}
+	}
+	return fmt.Errorf("condition not met")
+}
+
+// WaitForProgressBackoff waits for op to report done, up to maxTries attempts, with jittered exponential backoff
+// between attempts as WaitForReturnBackoff does. Whenever op reports that it progressed the backoff is reset, so
+// the next wait is initial again, and during stalls it grows by factor up to max.
+func WaitForProgressBackoff(ctx context.Context, initial, max time.Duration, factor float64, maxTries uint, op func() (done bool, progressed bool)) error {
+	return waitForProgressBackoff(ctx, realClock{}, initial, max, factor, maxTries, op)
+}
+
+func waitForRate(ctx context.Context, c clock, interval time.Duration, maxTries uint, op func() bool) error {
+	if err := validateInterval(interval, maxTries); err != nil {
+		return err
+	}
+
+	start := c.Now()
+	return waitUntilIntervals(ctx, c, maxTries, func() (bool, time.Duration) {
+		done := op()
+		if done || interval <= 0 {
+			return done, 0
+		}
+		// wait for the next tick on the schedule, skipping any that passed while op was running
+		elapsed := c.Now().Sub(start)
+		return false, (elapsed/interval+1)*interval - elapsed
+	})
+}
+
+// WaitForRate waits for op to return true, checking up to maxTries times on a fixed schedule of one check every
+// interval from the start, rather than interval after each check completes, so a slow op doesn't stretch the
+// schedule. If op overruns the interval the missed ticks are skipped and the next check waits for the next tick.
+func WaitForRate(ctx context.Context, interval time.Duration, maxTries uint, op func() bool) error {
+	return waitForRate(ctx, realClock{}, interval, maxTries, op)
 }
@@ -0,0 +1,982 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock advances instantly, recording each requested wait
+type fakeClock struct {
+	mu    sync.Mutex
+	now   time.Time
+	waits []time.Duration
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(0, 0)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	c.waits = append(c.waits, d)
+
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+// Advance moves the clock forward by d without recording a wait, e.g. to simulate a slow op
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func (c *fakeClock) Waits() []time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]time.Duration(nil), c.waits...)
+}
+
+func TestWaitUntil(t *testing.T) {
+	tests := []struct {
+		name             string
+		maxTries         uint
+		succeedOn        int
+		expectedAttempts int
+		expectedWaits    int
+		errorExpected    bool
+	}{
+		{name: "immediate success", maxTries: 5, succeedOn: 1, expectedAttempts: 1, expectedWaits: 0},
+		{name: "success on third attempt", maxTries: 5, succeedOn: 3, expectedAttempts: 3, expectedWaits: 2},
+		{name: "success on last attempt", maxTries: 5, succeedOn: 5, expectedAttempts: 5, expectedWaits: 4},
+		{name: "never succeeds", maxTries: 5, succeedOn: 0, expectedAttempts: 5, expectedWaits: 4, errorExpected: true},
+		{name: "no tries", maxTries: 0, succeedOn: 1, expectedAttempts: 0, expectedWaits: 0, errorExpected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newFakeClock()
+			attempts := 0
+			err := waitUntil(context.Background(), c, time.Second, tt.maxTries, func() bool {
+				attempts++
+				return attempts == tt.succeedOn
+			})
+
+			if tt.errorExpected && err == nil {
+				t.Errorf("expected error")
+			}
+			if !tt.errorExpected && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			if attempts != tt.expectedAttempts {
+				t.Errorf("expected %d attempts got %d", tt.expectedAttempts, attempts)
+			}
+
+			waits := c.Waits()
+			if len(waits) != tt.expectedWaits {
+				t.Errorf("expected %d waits got %d", tt.expectedWaits, len(waits))
+			}
+			for _, w := range waits {
+				if w != time.Second {
+					t.Errorf("expected wait of %s got %s", time.Second, w)
+				}
+			}
+		})
+	}
+}
+
+func TestWaitForReturnFakeClock(t *testing.T) {
+	c := newFakeClock()
+	attempts := 0
+	value, err := waitForReturn(context.Background(), c, 2*time.Second, 5, func() (*string, error) {
+		attempts++
+		if attempts < 3 {
+			return nil, errors.New("not yet")
+		}
+		v := "done"
+		return &v, nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if *value != "done" {
+		t.Errorf("expected 'done' got '%s'", *value)
+	}
+	if attempts != 3 {
+		t.Errorf("expected 3 attempts got %d", attempts)
+	}
+	if elapsed := c.Now().Sub(time.Unix(0, 0)); elapsed != 4*time.Second {
+		t.Errorf("expected 4s to elapse got %s", elapsed)
+	}
+}
+
+func TestWaitForReturnFakeClockZeroTries(t *testing.T) {
+	c := newFakeClock()
+	attempts := 0
+	_, err := waitForReturn(context.Background(), c, time.Second, 0, func() (*string, error) {
+		attempts++
+		return nil, errors.New("not yet")
+	})
+	if err == nil {
+		t.Errorf("expected error")
+	}
+	if attempts != 1 {
+		t.Errorf("expected 1 attempt got %d", attempts)
+	}
+	if len(c.Waits()) != 0 {
+		t.Errorf("expected no waits got %d", len(c.Waits()))
+	}
+}
+
+func TestWaitUntilContextCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	attempts := 0
+	err := waitUntil(ctx, newFakeClock(), time.Second, 5, func() bool {
+		attempts++
+		return false
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled got %v", err)
+	}
+	if attempts != 0 {
+		t.Errorf("expected no attempts got %d", attempts)
+	}
+}
+
+func readWaitChan(t *testing.T, ch <-chan error) error {
+	t.Helper()
+	select {
+	case err := <-ch:
+		if _, ok := <-ch; ok {
+			t.Errorf("expected channel to be closed after result")
+		}
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for result")
+		return nil
+	}
+}
+
+func TestWaitForChan(t *testing.T) {
+	attempts := 0
+	err := readWaitChan(t, WaitForChan(context.Background(), time.Millisecond, 5, func() bool {
+		attempts++
+		return attempts == 3
+	}))
+	if err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestWaitForChanTimeout(t *testing.T) {
+	err := readWaitChan(t, WaitForChan(context.Background(), time.Millisecond, 3, func() bool {
+		return false
+	}))
+	if err == nil {
+		t.Errorf("expected error")
+	}
+}
+
+func TestWaitForChanCanceled(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := WaitForChan(ctx, time.Hour, 5, func() bool {
+		return false
+	})
+	cancel()
+
+	err := readWaitChan(t, ch)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled got %v", err)
+	}
+
+	err = WaitFor(time.Millisecond, 1000, func() bool {
+		return runtime.NumGoroutine() <= before
+	})
+	if err != nil {
+		t.Errorf("expected goroutine to exit, have %d goroutines, started with %d", runtime.NumGoroutine(), before)
+	}
+}
+
+func TestWaitForStable(t *testing.T) {
+	tests := []struct {
+		name             string
+		results          []bool
+		maxTries         uint
+		required         uint
+		expectedAttempts int
+		errorExpected    bool
+	}{
+		{
+			name:             "flickers then stabilizes",
+			results:          []bool{true, false, true, true, false, true, true, true, false},
+			maxTries:         10,
+			required:         3,
+			expectedAttempts: 8,
+		},
+		{
+			name:             "never stable long enough",
+			results:          []bool{true, true, false, true, true, false},
+			maxTries:         6,
+			required:         3,
+			expectedAttempts: 6,
+			errorExpected:    true,
+		},
+		{
+			name:             "zero required treated as one",
+			results:          []bool{false, true},
+			maxTries:         5,
+			required:         0,
+			expectedAttempts: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			err := waitForStable(context.Background(), newFakeClock(), time.Second, tt.maxTries, tt.required, func() bool {
+				result := tt.results[attempts%len(tt.results)]
+				attempts++
+				return result
+			})
+			if tt.errorExpected && err == nil {
+				t.Errorf("expected error")
+			}
+			if !tt.errorExpected && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			if attempts != tt.expectedAttempts {
+				t.Errorf("expected %d attempts got %d", tt.expectedAttempts, attempts)
+			}
+		})
+	}
+}
+
+func TestWaitForStableRealClock(t *testing.T) {
+	attempts := 0
+	err := WaitForStable(context.Background(), time.Millisecond, 10, 2, func() bool {
+		attempts++
+		return attempts > 2
+	})
+	if err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if attempts != 4 {
+		t.Errorf("expected 4 attempts got %d", attempts)
+	}
+}
+
+func TestWaitForGroup(t *testing.T) {
+	attempts := map[string]int{}
+	var mu sync.Mutex
+	countingOp := func(name string, succeedOn int) func() bool {
+		return func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			attempts[name]++
+			return attempts[name] >= succeedOn
+		}
+	}
+
+	err := WaitForGroup(context.Background(), time.Millisecond, 10, map[string]func() bool{
+		"db":    countingOp("db", 1),
+		"cache": countingOp("cache", 3),
+	})
+	if err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestWaitForGroupFailureCancelsOthers(t *testing.T) {
+	var mu sync.Mutex
+	slowAttempts := 0
+
+	err := WaitForGroup(context.Background(), 10*time.Millisecond, 3, map[string]func() bool{
+		"broken": func() bool {
+			return false
+		},
+		"slow": func() bool {
+			mu.Lock()
+			slowAttempts++
+			mu.Unlock()
+			time.Sleep(200 * time.Millisecond)
+			return false
+		},
+	})
+	if err == nil {
+		t.Fatalf("expected error")
+	}
+	if !strings.HasPrefix(err.Error(), "broken: ") {
+		t.Errorf("expected error to name broken got '%s'", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if slowAttempts != 1 {
+		t.Errorf("expected slow wait to be canceled after 1 attempt got %d", slowAttempts)
+	}
+}
+
+func TestWaitForReturnVerbose(t *testing.T) {
+	tests := []struct {
+		name           string
+		maxTries       uint
+		succeedOn      int
+		expectedErrors int
+		errorExpected  bool
+	}{
+		{name: "immediate success", maxTries: 5, succeedOn: 1, expectedErrors: 0},
+		{name: "success after failures", maxTries: 5, succeedOn: 3, expectedErrors: 2},
+		{name: "never succeeds", maxTries: 4, succeedOn: 0, expectedErrors: 4, errorExpected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			value, attemptErrors, err := waitForReturnVerbose(context.Background(), newFakeClock(), time.Second, tt.maxTries, func() (*int, error) {
+				attempts++
+				if attempts == tt.succeedOn {
+					return &attempts, nil
+				}
+				return nil, fmt.Errorf("attempt %d failed", attempts)
+			})
+
+			if tt.errorExpected && err == nil {
+				t.Errorf("expected error")
+			}
+			if !tt.errorExpected && (err != nil || value == nil) {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if len(attemptErrors) != tt.expectedErrors {
+				t.Fatalf("expected %d errors got %d", tt.expectedErrors, len(attemptErrors))
+			}
+			for i, attemptErr := range attemptErrors {
+				if expected := fmt.Sprintf("attempt %d failed", i+1); attemptErr.Error() != expected {
+					t.Errorf("expected '%s' got '%s'", expected, attemptErr)
+				}
+			}
+		})
+	}
+}
+
+func TestWaitForNilErrorFunc(t *testing.T) {
+	errUnavailable := errors.New("503 service unavailable")
+	errBadRequest := errors.New("400 bad request")
+	retryable := func(err error) bool {
+		return errors.Is(err, errUnavailable)
+	}
+
+	tests := []struct {
+		name             string
+		results          []error
+		maxTries         uint
+		expectedAttempts int
+		expectedErr      error
+	}{
+		{name: "immediate success", results: []error{nil}, maxTries: 5, expectedAttempts: 1},
+		{name: "retryable then success", results: []error{errUnavailable, errUnavailable, nil}, maxTries: 5, expectedAttempts: 3},
+		{name: "non-retryable aborts", results: []error{errUnavailable, errBadRequest, nil}, maxTries: 5, expectedAttempts: 2, expectedErr: errBadRequest},
+		{name: "retryable until timeout", results: []error{errUnavailable, errUnavailable, errUnavailable}, maxTries: 3, expectedAttempts: 3, expectedErr: errUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			err := waitForNilErrorFunc(context.Background(), newFakeClock(), time.Second, tt.maxTries, func() error {
+				result := tt.results[attempts]
+				attempts++
+				return result
+			}, retryable)
+
+			if tt.expectedErr == nil && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			if tt.expectedErr != nil && !errors.Is(err, tt.expectedErr) {
+				t.Errorf("expected '%v' got '%v'", tt.expectedErr, err)
+			}
+			if attempts != tt.expectedAttempts {
+				t.Errorf("expected %d attempts got %d", tt.expectedAttempts, attempts)
+			}
+		})
+	}
+}
+
+func TestWaitForNilErrorFuncCancelled(t *testing.T) {
+	errUnavailable := errors.New("503 service unavailable")
+	ctx, cancel := context.WithCancel(context.Background())
+
+	attempts := 0
+	err := waitForNilErrorFunc(ctx, newFakeClock(), time.Second, 5, func() error {
+		attempts++
+		if attempts == 2 {
+			cancel()
+		}
+		return errUnavailable
+	}, func(error) bool { return true })
+
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled got '%v'", err)
+	}
+	if !errors.Is(err, errUnavailable) {
+		t.Errorf("expected error wrapping '%v' got '%v'", errUnavailable, err)
+	}
+	if attempts != 2 {
+		t.Errorf("expected 2 attempts got %d", attempts)
+	}
+}
+
+func TestBackoffInterval(t *testing.T) {
+	tests := []struct {
+		name     string
+		initial  time.Duration
+		max      time.Duration
+		factor   float64
+		attempt  uint
+		expected time.Duration
+	}{
+		{name: "first attempt", initial: time.Second, max: time.Minute, factor: 2, attempt: 0, expected: time.Second},
+		{name: "grows by factor", initial: time.Second, max: time.Minute, factor: 2, attempt: 3, expected: 8 * time.Second},
+		{name: "capped at max", initial: time.Second, max: 5 * time.Second, factor: 2, attempt: 10, expected: 5 * time.Second},
+		{name: "no max", initial: time.Second, factor: 3, attempt: 4, expected: 81 * time.Second},
+		{name: "factor below one is constant", initial: time.Second, max: time.Minute, factor: 0.5, attempt: 5, expected: time.Second},
+		{name: "no max high attempt", initial: time.Second, factor: 2, attempt: 100, expected: maxBackoffInterval},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 100; i++ {
+				interval := backoffInterval(tt.initial, tt.max, tt.factor, tt.attempt)
+				if interval < tt.expected/2 || interval > tt.expected {
+					t.Fatalf("expected interval in [%s, %s] got %s", tt.expected/2, tt.expected, interval)
+				}
+			}
+		})
+	}
+}
+
+func TestWaitForReturnBackoff(t *testing.T) {
+	c := newFakeClock()
+	attempts := 0
+	value, err := waitForReturnBackoff(context.Background(), c, 100*time.Millisecond, time.Minute, 2, 10, func() (*int, error) {
+		attempts++
+		if attempts < 6 {
+			return nil, errors.New("429 too many requests")
+		}
+		return &attempts, nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if *value != 6 {
+		t.Errorf("expected 6 got %d", *value)
+	}
+
+	waits := c.Waits()
+	if len(waits) != 5 {
+		t.Fatalf("expected 5 waits got %d", len(waits))
+	}
+	for i := 1; i < len(waits); i++ {
+		if waits[i] < waits[i-1] {
+			t.Errorf("expected growing delays got %v", waits)
+		}
+	}
+	if waits[len(waits)-1] < 800*time.Millisecond {
+		t.Errorf("expected last delay of at least 800ms got %s", waits[len(waits)-1])
+	}
+}
+
+func TestWaitForReturnBackoffNoMax(t *testing.T) {
+	c := newFakeClock()
+	attempts := 0
+	_, err := waitForReturnBackoff(context.Background(), c, time.Second, 0, 2, 100, func() (*int, error) {
+		attempts++
+		return nil, errors.New("unavailable")
+	})
+	if err == nil {
+		t.Errorf("expected error")
+	}
+	if attempts != 100 {
+		t.Errorf("expected 100 attempts got %d", attempts)
+	}
+	for _, w := range c.Waits() {
+		if w <= 0 || w > maxBackoffInterval {
+			t.Fatalf("expected delays in (0, %s] got %s", maxBackoffInterval, w)
+		}
+	}
+}
+
+func TestWaitForReturnBackoffTimeout(t *testing.T) {
+	c := newFakeClock()
+	errLimited := errors.New("429 too many requests")
+	attempts := 0
+	_, err := waitForReturnBackoff(context.Background(), c, 100*time.Millisecond, 250*time.Millisecond, 2, 4, func() (*int, error) {
+		attempts++
+		return nil, errLimited
+	})
+	if !errors.Is(err, errLimited) {
+		t.Errorf("expected error wrapping '%v' got '%v'", errLimited, err)
+	}
+	if attempts != 4 {
+		t.Errorf("expected 4 attempts got %d", attempts)
+	}
+	for _, w := range c.Waits() {
+		if w > 250*time.Millisecond {
+			t.Errorf("expected delays capped at 250ms got %s", w)
+		}
+	}
+}
+
+func TestWaitForProcessExitFakeClock(t *testing.T) {
+	checks := 0
+	exists := func(pid int) (bool, error) {
+		checks++
+		return checks < 3, nil
+	}
+
+	if err := waitForProcessExit(context.Background(), newFakeClock(), exists, time.Second, 5, 1234); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if checks != 3 {
+		t.Errorf("expected 3 checks got %d", checks)
+	}
+
+	err := waitForProcessExit(context.Background(), newFakeClock(), func(int) (bool, error) { return true, nil }, time.Second, 3, 1234)
+	if err == nil || !strings.Contains(err.Error(), "1234") {
+		t.Errorf("expected error naming pid got %v", err)
+	}
+
+	if err := waitForProcessExit(context.Background(), newFakeClock(), exists, time.Second, 3, 0); err == nil {
+		t.Errorf("expected error for invalid pid")
+	}
+}
+
+func TestWaitForProcessExit(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("relies on the sleep command")
+	}
+
+	cmd := exec.Command("sleep", "0.1")
+	if err := cmd.Start(); err != nil {
+		t.Skipf("unable to start process: %s", err)
+	}
+	// reap the child so it doesn't linger as a zombie
+	go func() { _ = cmd.Wait() }()
+
+	if err := WaitForProcessExit(context.Background(), 10*time.Millisecond, 500, cmd.Process.Pid); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestWaitForProcessExitTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("relies on the sleep command")
+	}
+
+	cmd := exec.Command("sleep", "10")
+	if err := cmd.Start(); err != nil {
+		t.Skipf("unable to start process: %s", err)
+	}
+	t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	})
+
+	if err := WaitForProcessExit(context.Background(), time.Millisecond, 3, cmd.Process.Pid); err == nil {
+		t.Errorf("expected error for running process")
+	}
+}
+
+func TestWaitForProgress(t *testing.T) {
+	ready := 0
+	total := 4
+	reported := make([]float64, 0)
+
+	err := waitForProgress(context.Background(), newFakeClock(), time.Second, 10, func() (bool, float64) {
+		progress := float64(ready) / float64(total)
+		done := ready == total
+		ready++
+		return done, progress
+	}, func(progress float64) {
+		reported = append(reported, progress)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := []float64{0, 0.25, 0.5, 0.75, 1}
+	if fmt.Sprint(reported) != fmt.Sprint(expected) {
+		t.Errorf("expected '%v' got '%v'", expected, reported)
+	}
+}
+
+func TestWaitForProgressTimeoutAndClamp(t *testing.T) {
+	reported := make([]float64, 0)
+	progress := []float64{-0.5, 0.5, 1.5}
+	attempt := 0
+
+	err := waitForProgress(context.Background(), newFakeClock(), time.Second, 3, func() (bool, float64) {
+		p := progress[attempt]
+		attempt++
+		return false, p
+	}, func(p float64) {
+		reported = append(reported, p)
+	})
+	if err == nil {
+		t.Errorf("expected error")
+	}
+	if expected := []float64{0, 0.5, 1}; fmt.Sprint(reported) != fmt.Sprint(expected) {
+		t.Errorf("expected '%v' got '%v'", expected, reported)
+	}
+
+	if err := waitForProgress(context.Background(), newFakeClock(), time.Second, 1, func() (bool, float64) { return true, 1 }, nil); err != nil {
+		t.Errorf("unexpected error with nil callback: %s", err)
+	}
+}
+
+func TestWaitForReturnStopOn(t *testing.T) {
+	errNotFound := errors.New("404 not found")
+	errUnavailable := errors.New("503 service unavailable")
+	stop := func(err error) bool {
+		return errors.Is(err, errNotFound)
+	}
+
+	tests := []struct {
+		name             string
+		results          []error
+		maxTries         uint
+		expectedAttempts int
+		expectedErr      error
+		errorExpected    bool
+	}{
+		{name: "immediate success", results: []error{nil}, maxTries: 5, expectedAttempts: 1},
+		{name: "transient then success", results: []error{errUnavailable, errUnavailable, nil}, maxTries: 5, expectedAttempts: 3},
+		{name: "terminal stops early", results: []error{errUnavailable, errNotFound, nil}, maxTries: 5, expectedAttempts: 2, expectedErr: errNotFound, errorExpected: true},
+		{name: "transient until timeout", results: []error{errUnavailable, errUnavailable, errUnavailable}, maxTries: 3, expectedAttempts: 3, errorExpected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			value, err := waitForReturnStopOn(context.Background(), newFakeClock(), time.Second, tt.maxTries, func() (*int, error) {
+				result := tt.results[attempts]
+				attempts++
+				if result != nil {
+					return nil, result
+				}
+				return &attempts, nil
+			}, stop)
+
+			if tt.errorExpected && err == nil {
+				t.Errorf("expected error")
+			}
+			if !tt.errorExpected && (err != nil || value == nil) {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if tt.expectedErr != nil && !errors.Is(err, tt.expectedErr) {
+				t.Errorf("expected '%v' got '%v'", tt.expectedErr, err)
+			}
+			if attempts != tt.expectedAttempts {
+				t.Errorf("expected %d attempts got %d", tt.expectedAttempts, attempts)
+			}
+		})
+	}
+}
+
+func TestWaitForCapped(t *testing.T) {
+	tests := []struct {
+		name             string
+		interval         time.Duration
+		maxTries         uint
+		maxTotal         time.Duration
+		succeedOn        int
+		expectedAttempts int
+		expectedWaits    []time.Duration
+		errorContains    string
+	}{
+		{
+			name:             "max total reached first",
+			interval:         10 * time.Second,
+			maxTries:         100,
+			maxTotal:         25 * time.Second,
+			expectedAttempts: 4,
+			expectedWaits:    []time.Duration{10 * time.Second, 10 * time.Second, 5 * time.Second},
+			errorContains:    "within 25s",
+		},
+		{
+			name:             "max tries reached first",
+			interval:         time.Second,
+			maxTries:         3,
+			maxTotal:         time.Minute,
+			expectedAttempts: 3,
+			expectedWaits:    []time.Duration{time.Second, time.Second},
+			errorContains:    "condition not met",
+		},
+		{
+			name:             "success before cap",
+			interval:         10 * time.Second,
+			maxTries:         100,
+			maxTotal:         25 * time.Second,
+			succeedOn:        2,
+			expectedAttempts: 2,
+			expectedWaits:    []time.Duration{10 * time.Second},
+		},
+		{
+			name:             "no cap",
+			interval:         10 * time.Second,
+			maxTries:         4,
+			expectedAttempts: 4,
+			expectedWaits:    []time.Duration{10 * time.Second, 10 * time.Second, 10 * time.Second},
+			errorContains:    "condition not met",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newFakeClock()
+			attempts := 0
+			err := waitForCapped(context.Background(), c, tt.interval, tt.maxTries, tt.maxTotal, func() bool {
+				attempts++
+				return attempts == tt.succeedOn
+			})
+
+			if tt.errorContains == "" && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			if tt.errorContains != "" && (err == nil || !strings.Contains(err.Error(), tt.errorContains)) {
+				t.Errorf("expected error containing '%s' got '%v'", tt.errorContains, err)
+			}
+			if attempts != tt.expectedAttempts {
+				t.Errorf("expected %d attempts got %d", tt.expectedAttempts, attempts)
+			}
+			if waits := c.Waits(); fmt.Sprint(waits) != fmt.Sprint(tt.expectedWaits) {
+				t.Errorf("expected waits %v got %v", tt.expectedWaits, waits)
+			}
+		})
+	}
+}
+
+func TestWaitForProgressBackoff(t *testing.T) {
+	progressed := []bool{false, false, false, true, false, false, false}
+	attempt := 0
+
+	c := newFakeClock()
+	err := waitForProgressBackoff(context.Background(), c, time.Second, time.Minute, 2, 10, func() (bool, bool) {
+		p := progressed[attempt]
+		attempt++
+		return attempt == len(progressed), p
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// the interval grows during the stall, resets after progress, then grows again
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, time.Second, 2 * time.Second, 4 * time.Second}
+	waits := c.Waits()
+	if len(waits) != len(expected) {
+		t.Fatalf("expected %d waits got %d", len(expected), len(waits))
+	}
+	for i, w := range waits {
+		if w < expected[i]/2 || w > expected[i] {
+			t.Errorf("wait %d: expected in [%s, %s] got %s", i, expected[i]/2, expected[i], w)
+		}
+	}
+}
+
+func TestWaitForProgressBackoffTimeout(t *testing.T) {
+	attempts := 0
+	err := waitForProgressBackoff(context.Background(), newFakeClock(), time.Second, 2*time.Second, 2, 3, func() (bool, bool) {
+		attempts++
+		return false, false
+	})
+	if err == nil {
+		t.Errorf("expected error")
+	}
+	if attempts != 3 {
+		t.Errorf("expected 3 attempts got %d", attempts)
+	}
+}
+
+func TestWaitForRate(t *testing.T) {
+	tests := []struct {
+		name           string
+		interval       time.Duration
+		opDuration     time.Duration
+		expectedChecks []time.Duration
+	}{
+		{
+			name:           "slow op stays on schedule",
+			interval:       50 * time.Millisecond,
+			opDuration:     30 * time.Millisecond,
+			expectedChecks: []time.Duration{0, 50 * time.Millisecond, 100 * time.Millisecond, 150 * time.Millisecond, 200 * time.Millisecond},
+		},
+		{
+			name:           "overrun skips missed ticks",
+			interval:       40 * time.Millisecond,
+			opDuration:     60 * time.Millisecond,
+			expectedChecks: []time.Duration{0, 80 * time.Millisecond, 160 * time.Millisecond, 240 * time.Millisecond, 320 * time.Millisecond},
+		},
+		{
+			name:           "op ending on a tick waits for the next",
+			interval:       40 * time.Millisecond,
+			opDuration:     40 * time.Millisecond,
+			expectedChecks: []time.Duration{0, 80 * time.Millisecond, 160 * time.Millisecond, 240 * time.Millisecond, 320 * time.Millisecond},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newFakeClock()
+			start := c.Now()
+
+			checks := make([]time.Duration, 0)
+			err := waitForRate(context.Background(), c, tt.interval, uint(len(tt.expectedChecks)), func() bool {
+				checks = append(checks, c.Now().Sub(start))
+				c.Advance(tt.opDuration)
+				return false
+			})
+			if err == nil {
+				t.Errorf("expected error")
+			}
+			if !reflect.DeepEqual(checks, tt.expectedChecks) {
+				t.Errorf("expected checks at %v got %v", tt.expectedChecks, checks)
+			}
+		})
+	}
+}
+
+func TestWaitForRateSuccess(t *testing.T) {
+	c := newFakeClock()
+	attempts := 0
+	err := waitForRate(context.Background(), c, time.Second, 5, func() bool {
+		attempts++
+		return attempts == 3
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if attempts != 3 {
+		t.Errorf("expected 3 attempts got %d", attempts)
+	}
+	if waits := c.Waits(); len(waits) != 2 {
+		t.Errorf("expected 2 waits got %v", waits)
+	}
+}
+
+func TestWaitForRateInvalid(t *testing.T) {
+	if err := WaitForRate(context.Background(), 0, 3, func() bool { return true }); !errors.Is(err, ErrInvalidInterval) {
+		t.Errorf("expected ErrInvalidInterval got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := WaitForRate(ctx, time.Millisecond, 3, func() bool { return true }); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled got %v", err)
+	}
+}
+
+func TestWaitUntilInvalidInterval(t *testing.T) {
+	errNotReady := errors.New("not ready")
+	returnOp := func(op func() bool) func() (*int, error) {
+		return func() (*int, error) {
+			op()
+			return nil, errNotReady
+		}
+	}
+
+	waits := []struct {
+		name string
+		// minTries is the number of attempts made when maxTries is 0
+		minTries int
+		wait     func(interval time.Duration, maxTries uint, op func() bool) error
+	}{
+		{name: "waitUntil", wait: func(interval time.Duration, maxTries uint, op func() bool) error {
+			return waitUntil(context.Background(), newFakeClock(), interval, maxTries, op)
+		}},
+		{name: "waitForReturn", minTries: 1, wait: func(interval time.Duration, maxTries uint, op func() bool) error {
+			_, err := waitForReturn(context.Background(), newFakeClock(), interval, maxTries, returnOp(op))
+			return err
+		}},
+		{name: "waitForReturnStopOn", minTries: 1, wait: func(interval time.Duration, maxTries uint, op func() bool) error {
+			_, err := waitForReturnStopOn(context.Background(), newFakeClock(), interval, maxTries, returnOp(op), func(error) bool { return false })
+			return err
+		}},
+		{name: "waitForReturnBackoff", minTries: 1, wait: func(interval time.Duration, maxTries uint, op func() bool) error {
+			_, err := waitForReturnBackoff(context.Background(), newFakeClock(), interval, time.Minute, 2, maxTries, returnOp(op))
+			return err
+		}},
+		{name: "waitForCapped", wait: func(interval time.Duration, maxTries uint, op func() bool) error {
+			return waitForCapped(context.Background(), newFakeClock(), interval, maxTries, time.Minute, op)
+		}},
+		{name: "waitForProgressBackoff", wait: func(interval time.Duration, maxTries uint, op func() bool) error {
+			return waitForProgressBackoff(context.Background(), newFakeClock(), interval, time.Minute, 2, maxTries, func() (bool, bool) {
+				return op(), false
+			})
+		}},
+		{name: "WaitForRate", wait: func(interval time.Duration, maxTries uint, op func() bool) error {
+			return WaitForRate(context.Background(), interval, maxTries, op)
+		}},
+	}
+
+	tests := []struct {
+		name             string
+		interval         time.Duration
+		maxTries         uint
+		expectedAttempts int
+		errorExpected    bool
+	}{
+		{name: "zero interval multiple tries", interval: 0, maxTries: 3, expectedAttempts: 0, errorExpected: true},
+		{name: "negative interval multiple tries", interval: -time.Second, maxTries: 3, expectedAttempts: 0, errorExpected: true},
+		{name: "zero interval single try", interval: 0, maxTries: 1, expectedAttempts: 1},
+		{name: "zero interval no tries", interval: 0, maxTries: 0, expectedAttempts: 0},
+	}
+
+	for _, w := range waits {
+		for _, tt := range tests {
+			t.Run(w.name+"/"+tt.name, func(t *testing.T) {
+				attempts := 0
+				err := w.wait(tt.interval, tt.maxTries, func() bool {
+					attempts++
+					return false
+				})
+
+				if tt.errorExpected && !errors.Is(err, ErrInvalidInterval) {
+					t.Errorf("expected ErrInvalidInterval got %v", err)
+				}
+				if !tt.errorExpected && errors.Is(err, ErrInvalidInterval) {
+					t.Errorf("unexpected error: %s", err)
+				}
+				expected := tt.expectedAttempts
+				if tt.maxTries == 0 {
+					expected = w.minTries
+				}
+				if attempts != expected {
+					t.Errorf("expected %d attempts got %d", expected, attempts)
+				}
+			})
+		}
+	}
+
+	if err := WaitFor(0, 5, func() bool { return true }); !errors.Is(err, ErrInvalidInterval) {
+		t.Errorf("expected ErrInvalidInterval got %v", err)
+	}
+}
@@ -0,0 +1,92 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+func statFileState(path string) (fileState, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileState{}, err
+	}
+	return fileState{modTime: info.ModTime(), size: info.Size()}, nil
+}
+
+// WatchStruct polls a yaml/yml or json file every interval and, when it changes, reloads it via LoadStructFromFile.
+// Reloaded structs are sent on the first channel and stat or decode errors on the second until ctx is done,
+// at which point both channels are closed. It returns ErrInvalidInterval if interval isn't positive.
+func WatchStruct[T any](ctx context.Context, path string, interval time.Duration) (<-chan *T, <-chan error, error) {
+	if interval <= 0 {
+		return nil, nil, fmt.Errorf("%w: %v. expected a positive duration", ErrInvalidInterval, interval)
+	}
+
+	expandedPath, err := ExpandPath(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	lastState, err := statFileState(expandedPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	structs := make(chan *T)
+	errs := make(chan error)
+
+	go func() {
+		defer close(structs)
+		defer close(errs)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			state, err := statFileState(expandedPath)
+			if err != nil {
+				select {
+				case errs <- err:
+				case <-ctx.Done():
+					return
+				}
+				continue
+			}
+
+			if state == lastState {
+				continue
+			}
+			lastState = state
+
+			data, err := LoadStructFromFile[T](expandedPath)
+			if err != nil {
+				select {
+				case errs <- err:
+				case <-ctx.Done():
+					return
+				}
+				continue
+			}
+
+			select {
+			case structs <- data:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return structs, errs, nil
+}
@@ -0,0 +1,109 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchStruct(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"name": "one", "count": 1}`), 0600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	structs, errs, err := WatchStruct[testConfig](ctx, path, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"name": "two", "count": 2}`), 0600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(path, future, future); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	select {
+	case cfg := <-structs:
+		if cfg.Name != "two" || cfg.Count != 2 {
+			t.Errorf("expected {two 2} got %v", *cfg)
+		}
+	case err := <-errs:
+		t.Fatalf("unexpected error: %s", err)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for reloaded struct")
+	}
+
+	cancel()
+
+	select {
+	case _, ok := <-structs:
+		if ok {
+			t.Errorf("expected struct channel to be closed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for struct channel to close")
+	}
+}
+
+func TestWatchStructDecodeError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"name": "one", "count": 1}`), 0600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	structs, errs, err := WatchStruct[testConfig](ctx, path, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"name": `), 0600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(path, future, future); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	select {
+	case cfg := <-structs:
+		t.Fatalf("expected error got %v", cfg)
+	case err := <-errs:
+		if err == nil {
+			t.Errorf("expected non-nil error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for error")
+	}
+}
+
+func TestWatchStructMissingFile(t *testing.T) {
+	_, _, err := WatchStruct[testConfig](context.Background(), filepath.Join(t.TempDir(), "missing.json"), time.Second)
+	if err == nil {
+		t.Errorf("expected error for missing file")
+	}
+}
+
+func TestWatchStructInvalidInterval(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := SaveStructToFile(&testConfig{Name: "test"}, path); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	for _, interval := range []time.Duration{0, -time.Second} {
+		if _, _, err := WatchStruct[testConfig](context.Background(), path, interval); !errors.Is(err, ErrInvalidInterval) {
+			t.Errorf("expected ErrInvalidInterval for %v got %v", interval, err)
+		}
+	}
+}